@@ -33,5 +33,4 @@ func addrIP(a net.Addr) net.IP {
 	default:
 		return nil
 	}
-	return nil
 }
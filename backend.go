@@ -0,0 +1,211 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/KarpelesLab/fleet"
+)
+
+// CABackend provides the private key of the node CA. The key must be the same on all hosts of
+// the fleet, since each host generates its own CA certificate from it.
+type CABackend interface {
+	// Start is called once fleet is ready. It must call set with the CA key as soon as it is
+	// available, and again each time it changes.
+	Start(set func(crypto.Signer)) error
+}
+
+var (
+	caBackend   CABackend = &fleetDBBackend{}
+	caBackendLk sync.Mutex
+)
+
+// SetCABackend sets the backend the node CA key is obtained from. It must be called before fleet
+// becomes ready (typically in main), and defaults to FleetDBBackend().
+func SetCABackend(b CABackend) {
+	caBackendLk.Lock()
+	defer caBackendLk.Unlock()
+
+	caBackend = b
+}
+
+func getCABackend() CABackend {
+	caBackendLk.Lock()
+	defer caBackendLk.Unlock()
+
+	return caBackend
+}
+
+// setCAKey is passed to CABackend.Start
+func setCAKey(key crypto.Signer) {
+	if err := nodeCA.setPrivateKey(key); err != nil {
+		slog.Error(fmt.Sprintf("cockroachdb private key setting failed: %s", err), "event", "froach:update_key:fail")
+	}
+}
+
+// fleetDBBackend shares a PKCS#8 encoded key through fleet DB, generating it if needed. This is
+// the only backend supporting RotateCA.
+type fleetDBBackend struct {
+	set func(crypto.Signer)
+}
+
+// FleetDBBackend returns a CABackend storing the CA key in fleet DB (this is the default)
+func FleetDBBackend() CABackend {
+	return &fleetDBBackend{}
+}
+
+func (b *fleetDBBackend) Start(set func(crypto.Signer)) error {
+	b.set = set
+	nodeCA.watchKey(b.setKey)
+
+	ref, err := nodeCA.keyRef()
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		// no key? generate one
+		newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		kData, err := x509.MarshalPKCS8PrivateKey(newKey)
+		if err != nil {
+			return err
+		}
+		// let's try to use this key, unless another host stored its own first (see keyRef)
+		// DbSet will trigger the watcher, that will call set accordingly
+		return store().DbSet(nodeCA.dbKey+"!", kData)
+	}
+
+	// initially set the key
+	b.sync()
+	return nil
+}
+
+// setKey passes the key to set, keeping the current key if it was removed
+func (b *fleetDBBackend) setKey(key crypto.Signer) {
+	if key != nil {
+		b.set(key)
+	}
+}
+
+// sync loads the key from fleet DB, see syncKeys
+func (b *fleetDBBackend) sync() {
+	if b.set != nil {
+		nodeCA.syncKey(b.setKey)
+	}
+}
+
+// parseSigner parses a PKCS#8 encoded private key suitable for signing certificates
+func parseSigner(enc []byte) (crypto.Signer, error) {
+	dec, err := x509.ParsePKCS8PrivateKey(enc)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := dec.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T (must match crypto.Signer for x509 certificate generation)", dec)
+	}
+	return key, nil
+}
+
+type fileBackend struct {
+	fn string
+}
+
+// FileBackend returns a CABackend reading the CA key from a PEM file, which must be present on
+// all hosts
+func FileBackend(fn string) CABackend {
+	return &fileBackend{fn: fn}
+}
+
+func (b *fileBackend) Start(set func(crypto.Signer)) error {
+	k, err := readPrivateKeyFile(b.fn)
+	if err != nil {
+		return err
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T in %s", k, b.fn)
+	}
+	set(key)
+	return nil
+}
+
+type signerBackend struct {
+	key crypto.Signer
+}
+
+// SignerBackend returns a CABackend using the given key, for example a key generated for tests or
+// a key stored in a HSM
+func SignerBackend(key crypto.Signer) CABackend {
+	return &signerBackend{key: key}
+}
+
+func (b *signerBackend) Start(set func(crypto.Signer)) error {
+	set(b.key)
+	return nil
+}
+
+type fleetKeyBackend struct{}
+
+// FleetKeyBackend returns a CABackend using the fleet shared key (see fleet.Agent.ExternalKey)
+func FleetKeyBackend() CABackend {
+	return &fleetKeyBackend{}
+}
+
+func (b *fleetKeyBackend) Start(set func(crypto.Signer)) error {
+	k, err := fleet.Self().ExternalKey()
+	if err != nil {
+		return err
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported fleet key type %T", k)
+	}
+	set(key)
+	return nil
+}
+
+type fleetSeedBackend struct{}
+
+// FleetSeedBackend returns a CABackend deriving a P-256 key from the fleet cryptseed, so the key
+// is never shared as raw bytes
+func FleetSeedBackend() CABackend {
+	return &fleetSeedBackend{}
+}
+
+func (b *fleetSeedBackend) Start(set func(crypto.Signer)) error {
+	h := fleet.Self().SeedShake256([]byte("froach:ca:key"))
+
+	buf := make([]byte, 32)
+	for {
+		if _, err := h.Read(buf); err != nil {
+			return err
+		}
+		// NewPrivateKey fails if the value is out of range, in which case we read more
+		k, err := ecdh.P256().NewPrivateKey(buf)
+		if err != nil {
+			continue
+		}
+
+		// convert to ecdsa through PKCS#8
+		enc, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return err
+		}
+		key, err := parseSigner(enc)
+		if err != nil {
+			return err
+		}
+		set(key)
+		return nil
+	}
+}
@@ -13,15 +13,21 @@ import (
 	"github.com/KarpelesLab/runutil"
 )
 
-// monitor will check if cockroachdb is launched every 1 min and launch it if needed
+// monitor will check certificates and if cockroachdb is launched every 1 min and launch it if needed
 func monitor() {
 	time.Sleep(5 * time.Second)
+	syncKeys()
+	certCheck()
+	rotationCheck()
 	cockroachCheck()
 
 	// initialize ticker only after running once since first run can take longer (cockroach download, etc)
 	t := time.NewTicker(time.Minute)
 
 	for _ = range t.C {
+		syncKeys()
+		certCheck()
+		rotationCheck()
 		cockroachCheck()
 	}
 }
@@ -43,6 +49,8 @@ func check() error {
 	pids := runutil.PidOf("cockroach")
 	if len(pids) > 0 {
 		// already got a cockroach process out there
+		enableOCSP()
+		checkNodeSpatial()
 		return nil
 	}
 
@@ -67,8 +75,20 @@ func check() error {
 		clusterName = "database"
 	}
 
+	// locate GEOS for spatial features
+	libDir := spatialLibDir(exe)
+	if libDir == "" {
+		slog.Warn(fmt.Sprintf("[froach] GEOS libraries not found for %s, spatial features will not be available", exe), "event", "froach:spatial:missing")
+	}
+	updateStatus(func(s *Status) {
+		s.Exe = exe
+		s.SpatialLibs = libDir
+		s.Spatial = false // confirmed by checkNodeSpatial once the node runs
+	})
+	spatialChecked = false
+
 	// make cmdline
-	cmdline := makeCmdline(clusterName, peers)
+	cmdline := makeCmdline(clusterName, peers, libDir)
 
 	// prepare command
 	c := exec.Command(exe, cmdline...)
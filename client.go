@@ -0,0 +1,201 @@
+package froach
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ClientCert is a certificate issued for a SQL user by the fleet CA
+type ClientCert struct {
+	User     string
+	Dir      string // directory the files were written to
+	CertPEM  []byte
+	KeyPEM   []byte
+	CAPEM    []byte
+	NotAfter time.Time
+}
+
+// ClientCertOption is an option for IssueClientCert
+type ClientCertOption func(s *certSpec)
+
+var validUser = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,62}$`)
+
+// WithUserDir stores the certificate in a directory dedicated to the user (see ClientCertDir)
+// together with a copy of ca.crt, so that the directory can be used as --certs-dir
+func WithUserDir() ClientCertOption {
+	return func(s *certSpec) {
+		s.dir = ClientCertDir(s.cn)
+	}
+}
+
+// ClientCertDir returns the per-user directory used by WithUserDir
+func ClientCertDir(user string) string {
+	return filepath.Join(basePath(), "users", user)
+}
+
+// IssueClientCert issues a certificate for the given SQL user valid for ttl, and writes it as
+// client.<user>.crt and client.<user>.key. Issued certificates are renewed automatically with
+// the same validity duration when these approach expiration.
+func IssueClientCert(user string, ttl time.Duration, opts ...ClientCertOption) (*ClientCert, error) {
+	if !validUser.MatchString(user) {
+		return nil, fmt.Errorf("invalid SQL user name %q", user)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("client certificate ttl must be positive")
+	}
+
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	spec := clientCertSpec(basePath(), user, ttl)
+	for _, o := range opts {
+		o(spec)
+	}
+	if spec.dir == basePath() && (user == "root" || user == "node") {
+		// these are managed by froach for the local node
+		return nil, fmt.Errorf("certificate for %s can only be issued with WithUserDir()", user)
+	}
+
+	if err := spec.create(); err != nil {
+		return nil, err
+	}
+	if err := spec.writeCA(); err != nil {
+		return nil, err
+	}
+
+	return loadClientCert(spec)
+}
+
+// clientCertSpec returns the certSpec for a client certificate stored in dir. keyLk must be held.
+func clientCertSpec(dir, user string, ttl time.Duration) *certSpec {
+	return &certSpec{
+		ca:      clientAuthority(),
+		dir:     dir,
+		crtFile: "client." + user + ".crt",
+		keyFile: "client." + user + ".key",
+		cn:      user,
+		ttl:     ttl,
+	}
+}
+
+// writeCA writes a copy of ca.crt next to the certificate when stored outside of basePath()
+func (s *certSpec) writeCA() error {
+	if s.dir == basePath() {
+		return nil
+	}
+	caPem := nodeCA.crtPem()
+	fn := filepath.Join(s.dir, "ca.crt")
+	if cur, err := os.ReadFile(fn); err == nil && bytes.Equal(cur, caPem) {
+		return nil
+	}
+	if err := os.WriteFile(fn, caPem, 0644); err != nil {
+		return fmt.Errorf("failed to write ca.crt: %w", err)
+	}
+	return nil
+}
+
+// loadClientCert reads the files of a client certificate
+func loadClientCert(s *certSpec) (*ClientCert, error) {
+	crt, err := readCertificateFile(s.path())
+	if err != nil {
+		return nil, err
+	}
+	keyPem, err := os.ReadFile(filepath.Join(s.dir, s.keyFile))
+	if err != nil {
+		return nil, err
+	}
+	crtPem, err := os.ReadFile(s.path())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ClientCert{
+		User:     s.cn,
+		Dir:      s.dir,
+		CertPEM:  crtPem,
+		KeyPEM:   keyPem,
+		CAPEM:    nodeCA.crtPem(),
+		NotAfter: crt.NotAfter,
+	}
+	return res, nil
+}
+
+// TLSConfig returns a tls.Config using this certificate, which can be used to connect to
+// cockroach (for example with pgconn.Config.TLSConfig)
+func (c *ClientCert) TLSConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c.CAPEM) {
+		return nil, errors.New("failed to parse CA certificate")
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return cfg, nil
+}
+
+// clientCertSpecs lists the client certificates issued by IssueClientCert found on disk. keyLk
+// must be held.
+func clientCertSpecs() []*certSpec {
+	var res []*certSpec
+
+	dirs := []string{basePath()}
+	if l, err := os.ReadDir(filepath.Join(basePath(), "users")); err == nil {
+		for _, ent := range l {
+			if ent.IsDir() {
+				dirs = append(dirs, ClientCertDir(ent.Name()))
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		l, _ := filepath.Glob(filepath.Join(dir, "client.*.crt"))
+		for _, fn := range l {
+			user := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fn), "client."), ".crt")
+			if dir == basePath() && (user == "root" || user == "node") {
+				// managed as part of the node certificates
+				continue
+			}
+
+			ttl := 365 * 24 * time.Hour
+			if crt, err := readCertificateFile(fn); err == nil {
+				ttl = crt.NotAfter.Sub(crt.NotBefore)
+			}
+			res = append(res, clientCertSpec(dir, user, ttl))
+		}
+	}
+	return res
+}
+
+// checkClientCerts renews issued client certificates if needed. keyLk must be held.
+func checkClientCerts() (bool, error) {
+	updated := false
+	var errs []error
+
+	for _, spec := range clientCertSpecs() {
+		upd, err := spec.check()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", spec.path(), err))
+			continue
+		}
+		updated = updated || upd
+		if err := spec.writeCA(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return updated, errors.Join(errs...)
+}
@@ -1,6 +1,7 @@
 package froach
 
 import (
+	"net/netip"
 	"path/filepath"
 	"strings"
 
@@ -8,10 +9,10 @@ import (
 	"github.com/KarpelesLab/goupd"
 )
 
-func makeCmdline(clusterName string, peers []string) []string {
+func makeCmdline(clusterName string, peers []string, libDir string) []string {
 	// check for goupd flags
 	if goupd.MODE == "DEV" {
-		return []string{
+		res := []string{
 			"start-single-node",
 			"--insecure",
 			"--store=type=mem,size=50%", // will disappear on stop
@@ -21,6 +22,7 @@ func makeCmdline(clusterName string, peers []string) []string {
 			"--http-addr",
 			"localhost:28081",
 		}
+		return append(res, spatialArgs(libDir)...)
 	}
 
 	res := []string{
@@ -40,10 +42,11 @@ func makeCmdline(clusterName string, peers []string) []string {
 	}
 
 	info, _ := cloudinfo.Load()
-	if ip, ok := info.PublicIP.GetFirstV4(); ok {
+	if ip, ok := advertiseIP(info); ok {
 		res = append(res, "--advertise-addr="+ip.String()+":36257")
 	}
 	res = append(res, cockroachLocalityArgs(info)...)
+	res = append(res, spatialArgs(libDir)...)
 
 	if len(peers) > 0 {
 		res = append(res, "--join="+strings.Join(peers, ","))
@@ -57,12 +60,45 @@ func cockroachLocalityArgs(info *cloudinfo.Info) []string {
 		"--locality=" + info.Location.String(),
 	}
 
-	region := info.Location.Get("region")
-	if region != "" {
-		if ip, ok := info.PrivateIP.GetFirstV4(); ok {
-			res = append(res, "--locality-advertise-addr=region="+region+"@"+ip.String()+":36257")
-		}
+	if ip, ok := localityAdvertiseIP(info); ok {
+		res = append(res, "--locality-advertise-addr=region="+info.Location.Get("region")+"@"+ip.String()+":36257")
 	}
 
 	return res
 }
+
+// advertiseIP returns the IP passed as --advertise-addr
+func advertiseIP(info *cloudinfo.Info) (netip.Addr, bool) {
+	return info.PublicIP.GetFirstV4()
+}
+
+// localityAdvertiseIP returns the IP passed as --locality-advertise-addr for nodes in the same region
+func localityAdvertiseIP(info *cloudinfo.Info) (netip.Addr, bool) {
+	if info.Location.Get("region") == "" {
+		return netip.Addr{}, false
+	}
+	return info.PrivateIP.GetFirstV4()
+}
+
+// nodeAltNames returns the names and addresses node.crt must be valid for, which includes
+// all the addresses cockroach may be reached at as well as the "node" principal
+func nodeAltNames() []string {
+	info, _ := cloudinfo.Load()
+	return nodeAltNamesFor(store().AltNames(), info)
+}
+
+// nodeAltNamesFor implements nodeAltNames for the given fleet alt names and cloud info
+func nodeAltNamesFor(altNames []string, info *cloudinfo.Info) []string {
+	res := append([]string{"node", "localhost", "127.0.0.1"}, altNames...)
+
+	if info != nil {
+		if ip, ok := advertiseIP(info); ok {
+			res = append(res, ip.String())
+		}
+		if ip, ok := localityAdvertiseIP(info); ok {
+			res = append(res, ip.String())
+		}
+	}
+
+	return normalizeAltNames(res)
+}
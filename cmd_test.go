@@ -0,0 +1,34 @@
+package froach
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/KarpelesLab/cloudinfo"
+)
+
+func TestNodeAltNames(t *testing.T) {
+	public := cloudinfo.IPList{netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("203.0.113.5")}
+	private := cloudinfo.IPList{netip.MustParseAddr("10.0.0.5")}
+	region := cloudinfo.LocationArray{{Type: "cloud", Value: "aws"}, {Type: "region", Value: "us-east-1"}}
+
+	tests := []struct {
+		name   string
+		info   *cloudinfo.Info
+		expect []string
+	}{
+		{"no info", nil, []string{"127.0.0.1", "host.example.com", "localhost", "node"}},
+		{"advertise", &cloudinfo.Info{PublicIP: public, PrivateIP: private}, []string{"127.0.0.1", "203.0.113.5", "host.example.com", "localhost", "node"}},
+		{"locality", &cloudinfo.Info{PublicIP: public, PrivateIP: private, Location: region}, []string{"10.0.0.5", "127.0.0.1", "203.0.113.5", "host.example.com", "localhost", "node"}},
+		{"locality without public", &cloudinfo.Info{PrivateIP: private, Location: region}, []string{"10.0.0.5", "127.0.0.1", "host.example.com", "localhost", "node"}},
+		{"duplicates", &cloudinfo.Info{PublicIP: cloudinfo.IPList{netip.MustParseAddr("127.0.0.1")}}, []string{"127.0.0.1", "host.example.com", "localhost", "node"}},
+	}
+
+	for _, tt := range tests {
+		res := nodeAltNamesFor([]string{"host.example.com"}, tt.info)
+		if !slices.Equal(res, tt.expect) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expect, res)
+		}
+	}
+}
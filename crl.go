@@ -0,0 +1,162 @@
+package froach
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CRLValidity is the validity of the CRL written next to each CA certificate. CRLs are
+// regenerated once half of it has passed, or when revocations change.
+var CRLValidity = 7 * 24 * time.Hour
+
+// revocation is stored in fleet DB under froach:crl:<serial>
+type revocation struct {
+	Revoked time.Time `json:"revoked"`
+}
+
+// revoked lists revoked serials (as returned by serialHex) with their revocation time, protected
+// by keyLk
+var revoked = make(map[string]time.Time)
+
+// RevokeCert revokes the certificate with the given serial number on all hosts. Each host lists
+// it in the CRL of its CAs, reports it as revoked through OCSP and reissues its own certificates
+// carrying this serial.
+func RevokeCert(serial *big.Int) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errors.New("invalid certificate serial number")
+	}
+
+	dat, err := json.Marshal(&revocation{Revoked: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := store().DbSet("froach:crl:"+serialHex(serial), dat); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("[froach] revoked certificate serial %s", serialHex(serial)), "event", "froach:crl:revoke")
+
+	// other hosts will pick it up on their next check
+	certCheck()
+	return nil
+}
+
+// RevokedCertificates returns the certificates revoked with RevokeCert
+func RevokedCertificates() ([]x509.RevocationListEntry, error) {
+	l, err := store().DbList("froach:crl:")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []x509.RevocationListEntry
+	for k, v := range l {
+		serial, ok := new(big.Int).SetString(k, 16)
+		if !ok {
+			continue
+		}
+		r := &revocation{}
+		if err := json.Unmarshal(v, r); err != nil {
+			slog.Warn(fmt.Sprintf("[froach] invalid revocation %s: %s", k, err), "event", "froach:crl:invalid")
+			continue
+		}
+		res = append(res, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: r.Revoked})
+	}
+	// keep a stable order so sameSerials doesn't see changes where there are none
+	slices.SortFunc(res, func(a, b x509.RevocationListEntry) int { return a.SerialNumber.Cmp(b.SerialNumber) })
+	return res, nil
+}
+
+// serialHex returns the hex encoded serial, as used in fleet DB and CertificateInfo
+func serialHex(serial *big.Int) string {
+	return hex.EncodeToString(serial.Bytes())
+}
+
+// isRevoked returns true if the certificate was revoked. keyLk must be held.
+func isRevoked(crt *x509.Certificate) bool {
+	_, ok := revoked[serialHex(crt.SerialNumber)]
+	return ok
+}
+
+// crlCheck loads revocations from fleet DB and updates the CRL of each authority. keyLk must be
+// held.
+func crlCheck() {
+	entries, err := RevokedCertificates()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[froach] failed to load revoked certificates: %s", err), "event", "froach:crl:load_fail")
+		return
+	}
+
+	revoked = make(map[string]time.Time)
+	for _, e := range entries {
+		revoked[serialHex(e.SerialNumber)] = e.RevocationTime
+	}
+
+	for _, a := range authorities() {
+		if !a.ready() {
+			continue
+		}
+		if err := a.checkCRL(entries); err != nil {
+			slog.Error(fmt.Sprintf("[froach] failed to update %s: %s", a.crlFile(), err), "event", "froach:crl:fail")
+		}
+	}
+}
+
+// crlFile returns the name of the file the CRL of the authority is written to
+func (a *authority) crlFile() string {
+	return strings.TrimSuffix(a.crtFile, ".crt") + ".crl"
+}
+
+// checkCRL writes a new CRL if revocations changed, the CA changed or the current CRL is halfway
+// through its validity. keyLk must be held.
+func (a *authority) checkCRL(entries []x509.RevocationListEntry) error {
+	if a.crl != nil && a.crl.CheckSignatureFrom(a.crt) == nil && sameSerials(a.crl.RevokedCertificateEntries, entries) && time.Until(a.crl.NextUpdate) > CRLValidity/2 {
+		return nil
+	}
+
+	now := time.Now()
+	tpl := &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(CRLValidity),
+		RevokedCertificateEntries: entries,
+	}
+	bin, err := x509.CreateRevocationList(rand.Reader, tpl, a.crt, a.key)
+	if err != nil {
+		return err
+	}
+	crl, err := x509.ParseRevocationList(bin)
+	if err != nil {
+		return fmt.Errorf("failed to parse freshly generated CRL: %w", err)
+	}
+
+	crlPem := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: bin})
+	if err := os.WriteFile(filepath.Join(basePath(), a.crlFile()), crlPem, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", a.crlFile(), err)
+	}
+	a.crl = crl
+	return nil
+}
+
+// sameSerials returns true if both lists contain the same serials in the same order
+func sameSerials(a, b []x509.RevocationListEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].SerialNumber.Cmp(b[i].SerialNumber) != 0 {
+			return false
+		}
+	}
+	return true
+}
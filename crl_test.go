@@ -0,0 +1,63 @@
+package froach
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCRLCheck(t *testing.T) {
+	s := testFleet(t)
+	testStartFleetDB(t)
+
+	keyLk.Lock()
+	oldRevoked := revoked
+	keyLk.Unlock()
+	t.Cleanup(func() {
+		keyLk.Lock()
+		revoked = oldRevoked
+		keyLk.Unlock()
+	})
+
+	s.DbSet("froach:crl:0a", []byte(`{"revoked":"2024-01-01T00:00:00Z"}`))
+	s.DbSet("froach:crl:02", []byte(`{"revoked":"2024-01-02T00:00:00Z"}`))
+	s.DbSet("froach:crl:zz", []byte(`{}`))
+
+	entries, err := RevokedCertificates()
+	if err != nil {
+		t.Fatalf("failed to list revoked certificates: %s", err)
+	}
+	if len(entries) != 2 || entries[0].SerialNumber.Int64() != 2 || entries[1].SerialNumber.Int64() != 10 {
+		t.Fatalf("unexpected revoked certificates %v", entries)
+	}
+	if !entries[1].RevocationTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected revocation time %s", entries[1].RevocationTime)
+	}
+
+	keyLk.Lock()
+	crlCheck()
+	ca := nodeCA.crt
+	keyLk.Unlock()
+
+	dat, err := os.ReadFile(filepath.Join(basePath(), "ca.crl"))
+	if err != nil {
+		t.Fatalf("failed to read ca.crl: %s", err)
+	}
+	blk, _ := pem.Decode(dat)
+	if blk == nil {
+		t.Fatalf("ca.crl is not PEM encoded")
+	}
+	crl, err := x509.ParseRevocationList(blk.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse ca.crl: %s", err)
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("ca.crl not signed by the CA: %s", err)
+	}
+	if !sameSerials(crl.RevokedCertificateEntries, entries) {
+		t.Errorf("ca.crl does not list the revoked certificates")
+	}
+}
@@ -1,7 +1,28 @@
 package froach
 
+import (
+	"net/url"
+	"path/filepath"
+
+	"github.com/KarpelesLab/goupd"
+)
+
 // DSN returns the DSN to connect to the cockroach server. Even if the server isn't running, a value will be returned
 func DSN() (string, error) {
 	v := "postgresql://root@localhost:26258/defaultdb?sslmode=disable"
 	return v, nil
 }
+
+// rootDSN returns the DSN froach uses to connect to the local node as root, authenticating with
+// client.root.crt
+func rootDSN() string {
+	if goupd.MODE == "DEV" {
+		return "postgresql://root@localhost:26257/defaultdb?sslmode=disable"
+	}
+
+	p := basePath()
+	return "postgresql://root@localhost:26257/defaultdb?sslmode=verify-full" +
+		"&sslrootcert=" + url.QueryEscape(filepath.Join(p, "ca.crt")) +
+		"&sslcert=" + url.QueryEscape(filepath.Join(p, "client.root.crt")) +
+		"&sslkey=" + url.QueryEscape(filepath.Join(p, "client.root.key"))
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -27,23 +28,60 @@ func Exe() (string, error) {
 	if runtime.GOOS == "linux" {
 		// if linux, check if azusa version is available, and return it if it is
 		if _, err := os.Stat("/pkg/main/dev-db.cockroach-bin.core/bin/cockroach"); err == nil {
-			return "/pkg/main/dev-db.cockroach-bin.core/bin/cockroach", nil
+			err = validateSystem("/pkg/main/dev-db.cockroach-bin.core/bin/cockroach")
+			if err == nil {
+				return "/pkg/main/dev-db.cockroach-bin.core/bin/cockroach", nil
+			}
+			slog.Warn(fmt.Sprintf("[froach] azusa cockroach is not usable, ignoring: %s", err), "event", "froach:exe:azusa_invalid")
 		}
 	}
 
 	v, err := GetLatestVersion()
+	if err != nil {
+		return installedFallback(err)
+	}
+
+	return v.install(true)
+}
+
+// ExeVersion returns the path to the given cockroach version (such as v23.2.5), downloading it
+// if needed
+func ExeVersion(vers string) (string, error) {
+	v, err := GetVersion(vers)
 	if err != nil {
 		return "", err
 	}
+	// no fallback, tests pinning a version should not silently run another one
+	return v.install(false)
+}
+
+// install makes sure the version is installed in cachePath() and usable, and returns the path to
+// its cockroach executable. If fallback is true and the version can't be fetched or the download
+// isn't usable, the newest installed version is returned instead.
+func (v *CockroachVersion) install(fallback bool) (string, error) {
 	p := cachePath()
 
-	if _, err = os.Stat(filepath.Join(p, v.Dirname())); err == nil {
-		// directory already exists, return exe
-		return filepath.Join(p, v.Dirname(), "cockroach"), nil
+	if _, err := os.Stat(filepath.Join(p, v.Dirname())); err == nil {
+		// directory already exists, make sure it is usable
+		err = v.Validate(p)
+		if err == nil {
+			return filepath.Join(p, v.Dirname(), "cockroach"), nil
+		}
+		slog.Warn(fmt.Sprintf("[froach] installed cockroach %s failed validation, fetching again: %s", v.Version(), err), "event", "froach:exe:invalid")
+		v.quarantine(p)
 	}
 
-	err = v.ExtractTo(p)
+	err := v.ExtractTo(p)
+	if err == nil {
+		if err = v.Validate(p); err != nil {
+			v.quarantine(p)
+			err = fmt.Errorf("downloaded cockroach %s is not usable: %w", v.Version(), err)
+		}
+	}
 	if err != nil {
+		if fallback {
+			return installedFallback(err)
+		}
 		return "", err
 	}
 
@@ -65,6 +103,24 @@ func (v *CockroachVersion) Dirname() string {
 	return v.Filename
 }
 
+// Version returns the cockroachdb version (such as v24.1.0) this file contains
+func (v *CockroachVersion) Version() string {
+	res := strings.TrimPrefix(v.Dirname(), "cockroach-")
+	if pos := strings.LastIndexByte(res, '.'); pos > 0 {
+		return res[:pos]
+	}
+	return res
+}
+
+// Platform returns the platform (such as linux-amd64) this file was built for
+func (v *CockroachVersion) Platform() string {
+	res := v.Dirname()
+	if pos := strings.LastIndexByte(res, '.'); pos > 0 {
+		return res[pos+1:]
+	}
+	return ""
+}
+
 // DownloadTo downloads the version of cockroachdb to a file while performing a checksum
 func (v *CockroachVersion) DownloadTo(fn string) error {
 	fp, err := os.Create(fn + "~")
@@ -142,13 +198,37 @@ func GetLatestVersion() (*CockroachVersion, error) {
 }
 
 // GetVersion gathers information on the specified cockroachdb version and returns a CockroachVersion.
+//
+// Results are cached in cachePath() for VersionCacheTTL, and a stale cached value will be returned
+// if the cockroach servers cannot be reached.
 func GetVersion(vers string) (*CockroachVersion, error) {
+	if res, err := readCachedVersion(vers, VersionCacheTTL); err == nil {
+		return res, nil
+	}
+
 	// https://binaries.cockroachdb.com/cockroach-$vers.linux-amd64.tgz.sha256sum
 	u := fmt.Sprintf("https://binaries.cockroachdb.com/cockroach-%s.%s-%s.tgz.sha256sum", vers, runtime.GOOS, runtime.GOARCH)
 	nfo, err := readStream(webutil.Get(u))
 	if err != nil {
+		if res, err2 := readCachedVersion(vers, 0); err2 == nil {
+			slog.Warn(fmt.Sprintf("[froach] failed to resolve cockroach version %s, using cached value %s: %s", vers, res.Version(), err), "event", "froach:version:stale")
+			return res, nil
+		}
 		return nil, err
 	}
+
+	res, err := parseSha256sum(nfo)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCachedVersion(vers, nfo)
+
+	return res, nil
+}
+
+// parseSha256sum parses a .sha256sum file as returned by cockroach servers
+func parseSha256sum(nfo []byte) (*CockroachVersion, error) {
 	nfoA := strings.Fields(string(nfo))
 	if len(nfoA) != 2 {
 		return nil, fmt.Errorf("unexpected response from server: %s", nfo)
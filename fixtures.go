@@ -0,0 +1,195 @@
+package froach
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	// testTemplates maps server DSN + fixtures hash to the location of a backup of the database
+	// created from these fixtures, protected by testTemplatesLk
+	testTemplates   = make(map[string]string)
+	testTemplatesLk sync.Mutex
+
+	// testTemplateSuffix is added to template database names, since testTemplates is per process
+	// while the server may be shared with other test binaries
+	testTemplateSuffix = newTemplateSuffix()
+)
+
+// testTemplateStore is the database holding the template backups of this process as userfiles,
+// so these can be dropped with it rather than left behind on servers we don't own
+func testTemplateStore() string {
+	return "froach_tpl_" + testTemplateSuffix
+}
+
+// newTemplateSuffix returns a random suffix for testTemplateSuffix
+func newTemplateSuffix() string {
+	rnd := make([]byte, 4)
+	rand.Read(rnd)
+	return hex.EncodeToString(rnd)
+}
+
+// sqlFiles returns the .sql files found in fsys in lexical order
+func sqlFiles(fsys fs.FS) ([]string, error) {
+	var res []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, ".sql") {
+			res = append(res, p)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// applySQL runs the .sql files of each given fs.FS on the database at dsn, in lexical order
+func applySQL(dsn string, fsyss ...fs.FS) error {
+	for _, fsys := range fsyss {
+		if fsys == nil {
+			continue
+		}
+		files, err := sqlFiles(fsys)
+		if err != nil {
+			return err
+		}
+		for _, fn := range files {
+			q, err := fs.ReadFile(fsys, fn)
+			if err != nil {
+				return err
+			}
+			if err := execSQL(dsn, string(q)); err != nil {
+				return fmt.Errorf("%s: %w", fn, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fixturesHash returns a hash of the contents of the given fixtures
+func fixturesHash(fsyss ...fs.FS) (string, error) {
+	h := sha256.New()
+	for i, fsys := range fsyss {
+		fmt.Fprintf(h, "fixtures %d\n", i)
+		if fsys == nil {
+			continue
+		}
+		files, err := sqlFiles(fsys)
+		if err != nil {
+			return "", err
+		}
+		for _, fn := range files {
+			q, err := fs.ReadFile(fsys, fn)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s %d\n", fn, len(q))
+			h.Write(q)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// createFromTemplate creates database name from the given fixtures on the server at dsn. The
+// first time given fixtures are used, these are applied to a template database which is backed
+// up, then restored under the requested name, which is much faster than running DDL each time.
+// If the server can't back it up, the fixtures are applied to each database instead. Backups are
+// dropped by StopLocalTestServer.
+func createFromTemplate(dsn, name string, schema, seed fs.FS) error {
+	hash, err := fixturesHash(schema, seed)
+	if err != nil {
+		return err
+	}
+	tplName := "froach_tpl_" + hash[:16] + "_" + testTemplateSuffix
+
+	loc, err := testTemplate(dsn, tplName, hash, schema, seed)
+	if err != nil {
+		return err
+	}
+
+	if loc == "" {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return err
+		}
+		u.Path = "/" + name
+
+		if err := execSQL(dsn, fmt.Sprintf("CREATE DATABASE %s", ident(name))); err != nil {
+			return err
+		}
+		if err := applySQL(u.String(), schema, seed); err != nil {
+			execSQL(dsn, fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", ident(name)))
+			return err
+		}
+		return nil
+	}
+
+	return execSQL(dsn, fmt.Sprintf("RESTORE DATABASE %s FROM LATEST IN %s WITH new_db_name = %s", ident(tplName), literal(loc), literal(name)))
+}
+
+// testTemplate returns the location of the backup of the template database for the given
+// fixtures, creating it if needed, or an empty string if the server can't back it up
+func testTemplate(dsn, tplName, hash string, schema, seed fs.FS) (string, error) {
+	testTemplatesLk.Lock()
+	defer testTemplatesLk.Unlock()
+
+	if loc, ok := testTemplates[dsn+"#"+hash]; ok {
+		return loc, nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	u.Path = "/" + tplName
+
+	if err := execSQL(dsn, fmt.Sprintf("CREATE DATABASE %s", ident(tplName))); err != nil {
+		return "", err
+	}
+	defer execSQL(dsn, fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", ident(tplName)))
+
+	if err := applySQL(u.String(), schema, seed); err != nil {
+		return "", err
+	}
+
+	loc := "userfile://" + testTemplateStore() + ".public.tpl/" + hash
+	err = execSQL(dsn,
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", ident(testTemplateStore())),
+		fmt.Sprintf("BACKUP DATABASE %s INTO %s", ident(tplName), literal(loc)),
+	)
+	if err != nil {
+		log.Printf("[froach] failed to back up template database, applying fixtures to each database: %s", err)
+		loc = ""
+	}
+
+	testTemplates[dsn+"#"+hash] = loc
+	return loc, nil
+}
+
+// dropTestTemplates drops the template backups made on each server and forgets about them
+func dropTestTemplates() error {
+	testTemplatesLk.Lock()
+	defer testTemplatesLk.Unlock()
+
+	var errs []error
+	seen := make(map[string]bool)
+	for k, loc := range testTemplates {
+		dsn, _, _ := strings.Cut(k, "#")
+		if loc == "" || seen[dsn] {
+			continue
+		}
+		seen[dsn] = true
+		errs = append(errs, execSQL(dsn, fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", ident(testTemplateStore()))))
+	}
+	clear(testTemplates)
+	return errors.Join(errs...)
+}
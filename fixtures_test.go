@@ -0,0 +1,55 @@
+package froach
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSQLFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_users.sql":      {Data: []byte("CREATE TABLE users (id INT PRIMARY KEY)")},
+		"001_init.sql":       {Data: []byte("CREATE TABLE init (id INT PRIMARY KEY)")},
+		"README.md":          {Data: []byte("not sql")},
+		"sub/003_orders.sql": {Data: []byte("CREATE TABLE orders (id INT PRIMARY KEY)")},
+	}
+
+	files, err := sqlFiles(fsys)
+	if err != nil {
+		t.Fatalf("sqlFiles failed: %s", err)
+	}
+	expect := []string{"001_init.sql", "002_users.sql", "sub/003_orders.sql"}
+	if !slices.Equal(files, expect) {
+		t.Errorf("expected %v, got %v", expect, files)
+	}
+}
+
+func TestFixturesHash(t *testing.T) {
+	schema := fstest.MapFS{"001.sql": {Data: []byte("CREATE TABLE a (id INT PRIMARY KEY)")}}
+	seed := fstest.MapFS{"001.sql": {Data: []byte("INSERT INTO a VALUES (1)")}}
+
+	a, _ := fixturesHash(schema, seed)
+	b, _ := fixturesHash(schema, nil)
+	c, _ := fixturesHash(nil, schema)
+	d, _ := fixturesHash(schema, seed)
+
+	if a == b || b == c {
+		t.Errorf("different fixtures should have different hashes")
+	}
+	if a != d {
+		t.Errorf("same fixtures should have the same hash")
+	}
+}
+
+func TestDropTestTemplates(t *testing.T) {
+	testTemplatesLk.Lock()
+	testTemplates["postgresql://root@localhost:1/defaultdb#abc"] = "" // fixtures applied to each database, nothing to drop
+	testTemplatesLk.Unlock()
+
+	if err := dropTestTemplates(); err != nil {
+		t.Fatalf("dropTestTemplates failed: %s", err)
+	}
+	if len(testTemplates) != 0 {
+		t.Errorf("expected templates to be forgotten, got %v", testTemplates)
+	}
+}
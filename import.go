@@ -0,0 +1,156 @@
+package froach
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// importTimeout is how long ImportCertsDir waits for the imported keys to be loaded
+const importTimeout = 10 * time.Second
+
+// ErrSplitPKIEnabled is returned by ImportCertsDir when importing a client CA while split PKI is
+// already enabled, since the client CA can't be rotated
+var ErrSplitPKIEnabled = errors.New("cannot import a client CA while split PKI is enabled")
+
+// importedSubject is stored in fleet DB under subjKey:<key hash> when a CA is imported
+type importedSubject struct {
+	Key     string `json:"key"`     // hash of the CA public key, see pubKeyHash
+	Subject []byte `json:"subject"` // DER encoded subject of the imported CA
+}
+
+// ImportCertsDir imports the CA of an existing cockroach certs directory (as created by
+// "cockroach cert create-ca") into fleet DB. The imported CA replaces the current CA of the fleet
+// through the same steps as RotateCA, so certificates issued by the current CA remain trusted
+// until all hosts have certificates issued by the imported CA. Progress can be followed through
+// GetStatus().Rotation. The imported CA keeps its subject, so certificates it issued before
+// remain valid as well.
+//
+// If the directory contains ca-client.key and ca-client.crt, split PKI is enabled with the
+// imported client CA. Like EnableSplitPKI this happens right away, and fails with
+// ErrSplitPKIEnabled if split PKI is already enabled.
+func ImportCertsDir(dir string) error {
+	if _, ok := getCABackend().(*fleetDBBackend); !ok {
+		return errors.New("importing a CA is only supported with FleetDBBackend")
+	}
+	if ref, err := nextCA.keyRef(); err != nil {
+		return err
+	} else if ref != "" {
+		return ErrRotationInProgress
+	}
+
+	var importClient bool
+	if _, err := os.Stat(filepath.Join(dir, "ca-client.key")); err == nil {
+		if ref, err := clientCA.keyRef(); err != nil {
+			return err
+		} else if ref != "" {
+			return ErrSplitPKIEnabled
+		}
+		importClient = true
+	}
+
+	// rotate to the imported node CA
+	if err := nodeCA.importFrom(dir, nextCA); err != nil {
+		return err
+	}
+
+	if importClient {
+		return clientCA.importFrom(dir, clientCA)
+	}
+	return nil
+}
+
+// importFrom imports the authority key and subject from the given directory, storing the key as
+// the key of target (the authority itself, or nextCA to rotate to it)
+func (a *authority) importFrom(dir string, target *authority) error {
+	keyFile := strings.TrimSuffix(a.crtFile, ".crt") + ".key"
+
+	crt, err := readCertificateFile(filepath.Join(dir, a.crtFile))
+	if err != nil {
+		return err
+	}
+	k, err := readPrivateKeyFile(filepath.Join(dir, keyFile))
+	if err != nil {
+		return err
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T in %s", k, keyFile)
+	}
+
+	if !crt.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", a.crtFile)
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(crt.PublicKey) {
+		return fmt.Errorf("%s does not match %s", keyFile, a.crtFile)
+	}
+
+	if target == nextCA && nodeCA.loaded(key) {
+		slog.Info(fmt.Sprintf("[froach] %s from %s is already the fleet CA", a.crtFile, dir), "event", "froach:ca:import_noop")
+		return nil
+	}
+
+	hash, err := pubKeyHash(key.Public())
+	if err != nil {
+		return err
+	}
+	subj, err := json.Marshal(&importedSubject{Key: hash, Subject: crt.RawSubject})
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("[froach] importing %s (%s) from %s", a.crtFile, crt.Subject, dir), "event", "froach:ca:import")
+
+	// the subject must be known before the key, since the watcher will generate the CA
+	if err := store().DbSet(a.subjKey+":"+hash, subj); err != nil {
+		return err
+	}
+	if err := target.storeKey(key); err != nil {
+		return err
+	}
+
+	// the key is loaded by the watcher
+	deadline := time.Now().Add(importTimeout)
+	for !target.loaded(key) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("imported %s key was not loaded", a.crtFile)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// loaded returns true if key is the current key of the authority
+func (a *authority) loaded(key crypto.Signer) bool {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return a.key != nil && sameKey(a.key, key)
+}
+
+// importedSubject returns the subject to use for the authority if it was imported with the key
+// matching the given hash. keyLk must be held.
+func (a *authority) importedSubject(hash string) []byte {
+	if a.subjKey == "" {
+		return nil
+	}
+	v, err := store().DbGet(a.subjKey + ":" + hash)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn(fmt.Sprintf("[froach] failed to read CA subject: %s", err), "event", "froach:ca:subject_fail")
+		}
+		return nil
+	}
+	s := &importedSubject{}
+	if err := json.Unmarshal(v, s); err != nil || s.Key != hash {
+		return nil
+	}
+	return s.Subject
+}
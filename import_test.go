@@ -0,0 +1,87 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportCertsDir(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+	clientCA.watchKey(updateClientKey)
+	nextCA.watchKey(updateNextKey)
+	oldKey := authorityKey(nodeCA)
+
+	caKey, ca := testCA(t)
+	_, leaf := testLeaf(t, caKey, ca, "node")
+
+	dir := t.TempDir()
+	writeTestCA(t, dir, "ca", caKey, ca)
+
+	if err := ImportCertsDir(dir); err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+
+	// the imported CA goes through the rotation steps
+	if !sameKey(authorityKey(nodeCA), oldKey) || !sameKey(authorityKey(nextCA), caKey) {
+		t.Fatalf("imported CA should be rotated in")
+	}
+	if err := ImportCertsDir(dir); err != ErrRotationInProgress {
+		t.Errorf("expected rotation in progress, got %v", err)
+	}
+	rotationCheck() // reissue
+	rotationCheck() // promote
+	if !sameKey(authorityKey(nodeCA), caKey) {
+		t.Fatalf("node CA key was not replaced by the imported key")
+	}
+
+	// certificates issued by the imported CA remain valid
+	keyLk.Lock()
+	roots := x509.NewCertPool()
+	roots.AddCert(nodeCA.crt)
+	keyLk.Unlock()
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("certificate issued by the imported CA is not valid anymore: %s", err)
+	}
+
+	// importing again, for example another CA, works as well
+	caKey2, ca2 := testCA(t)
+	writeTestCA(t, dir, "ca", caKey2, ca2)
+
+	if err := ImportCertsDir(dir); err != nil {
+		t.Fatalf("failed to import again: %s", err)
+	}
+	rotationCheck()
+	rotationCheck()
+	if !sameKey(authorityKey(nodeCA), caKey2) {
+		t.Fatalf("node CA key was not replaced by the second imported key")
+	}
+
+	// the client CA can't be replaced once split PKI is enabled
+	if err := EnableSplitPKI(); err != nil {
+		t.Fatalf("failed to enable split PKI: %s", err)
+	}
+	clientKey, clientCrt := testCA(t)
+	writeTestCA(t, dir, "ca-client", clientKey, clientCrt)
+	if err := ImportCertsDir(dir); err != ErrSplitPKIEnabled {
+		t.Errorf("expected %v, got %v", ErrSplitPKIEnabled, err)
+	}
+}
+
+// writeTestCA writes the key and certificate of a CA as name.key and name.crt in dir
+func writeTestCA(t *testing.T, dir, name string, key crypto.Signer, crt *x509.Certificate) {
+	keyBin, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBin}), 0600); err != nil {
+		t.Fatalf("failed to write %s.key: %s", name, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}), 0644); err != nil {
+		t.Fatalf("failed to write %s.crt: %s", name, err)
+	}
+}
@@ -0,0 +1,217 @@
+package froach
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/KarpelesLab/fleet"
+)
+
+// CertificateInfo describes a certificate found in the froach certs directory
+type CertificateInfo struct {
+	File        string    `json:"file"` // path relative to the certs directory
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	SANs        []string  `json:"sans,omitempty"`
+	Serial      string    `json:"serial"`      // hex encoded
+	Fingerprint string    `json:"fingerprint"` // sha256 of the DER certificate, hex encoded
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	IsCA        bool      `json:"is_ca,omitempty"`
+	KeyMatch    bool      `json:"key_match"` // true if the matching private key exists and matches the certificate
+}
+
+// HostCertificates is the certificate summary each host publishes to fleet DB
+type HostCertificates struct {
+	Id           string             `json:"id"`
+	Name         string             `json:"name"`
+	Updated      time.Time          `json:"updated"`
+	Certificates []*CertificateInfo `json:"certificates"`
+}
+
+// ExpiresWithin returns true if the certificate expires within the given duration
+func (c *CertificateInfo) ExpiresWithin(d time.Duration) bool {
+	return time.Until(c.NotAfter) < d
+}
+
+// Certificates returns information on all the certificates found in the froach certs directory,
+// including ca.crt, node.crt and client certificates. CA private keys are only kept in memory,
+// so CA certificates are checked against the keys currently loaded.
+func Certificates() ([]*CertificateInfo, error) {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return certificates()
+}
+
+// certificates implements Certificates. keyLk must be held.
+func certificates() ([]*CertificateInfo, error) {
+	p := basePath()
+
+	files := []string{"ca.crt", "ca-client.crt", "node.crt", "client.node.crt", "ui.crt"}
+	l, _ := filepath.Glob(filepath.Join(p, "client.*.crt"))
+	l2, _ := filepath.Glob(filepath.Join(p, "users", "*", "client.*.crt"))
+	for _, fn := range append(l, l2...) {
+		rel, err := filepath.Rel(p, fn)
+		if err != nil || rel == "client.node.crt" {
+			continue
+		}
+		files = append(files, rel)
+	}
+
+	var res []*CertificateInfo
+	for _, fn := range files {
+		nfo, err := readCertificateInfo(p, fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, nfo...)
+	}
+	return res, nil
+}
+
+// readCertificateInfo returns information on all the certificates found in a given file. keyLk
+// must be held.
+func readCertificateInfo(dir, fn string) ([]*CertificateInfo, error) {
+	dat, err := os.ReadFile(filepath.Join(dir, fn))
+	if err != nil {
+		return nil, err
+	}
+
+	// load the matching private key, if any
+	var key any
+	if strings.HasSuffix(fn, ".crt") {
+		key, _ = readPrivateKeyFile(filepath.Join(dir, strings.TrimSuffix(fn, ".crt")+".key"))
+	}
+
+	var res []*CertificateInfo
+	for {
+		var b *pem.Block
+		b, dat = pem.Decode(dat)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		crt, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", fn, err)
+		}
+		if crt.IsCA {
+			key = caKeyFor(crt)
+		}
+		res = append(res, certificateInfo(fn, crt, key))
+	}
+	return res, nil
+}
+
+// certificateInfo returns the CertificateInfo for a given certificate and its private key
+func certificateInfo(fn string, crt *x509.Certificate, key any) *CertificateInfo {
+	fp := sha256.Sum256(crt.Raw)
+
+	res := &CertificateInfo{
+		File:        fn,
+		Subject:     crt.Subject.String(),
+		Issuer:      crt.Issuer.String(),
+		SANs:        crtAltNames(crt),
+		Serial:      hex.EncodeToString(crt.SerialNumber.Bytes()),
+		Fingerprint: hex.EncodeToString(fp[:]),
+		NotBefore:   crt.NotBefore,
+		NotAfter:    crt.NotAfter,
+		IsCA:        crt.IsCA,
+	}
+
+	if signer, ok := key.(interface{ Public() crypto.PublicKey }); ok {
+		if pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool }); ok {
+			res.KeyMatch = pub.Equal(crt.PublicKey)
+		}
+	}
+
+	return res
+}
+
+// caKeyFor returns the private key of the loaded authority matching the CA certificate, if any.
+// CAs trusted during a rotation but whose key was replaced have no key. keyLk must be held.
+func caKeyFor(crt *x509.Certificate) crypto.Signer {
+	for _, a := range append(authorities(), nextCA) {
+		if a.ready() && a.crt.Equal(crt) {
+			return a.key
+		}
+	}
+	return nil
+}
+
+var (
+	lastPublished     []byte // protected by keyLk
+	lastPublishedTime time.Time
+)
+
+// publishCertificates publishes the local certificates summary to fleet DB if it changed or
+// wasn't published for a day. keyLk must be held.
+func publishCertificates() {
+	l, err := certificates()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[froach] failed to list certificates: %s", err), "event", "froach:certs:list_fail")
+		return
+	}
+	cmp, err := json.Marshal(l)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(cmp, lastPublished) && time.Since(lastPublishedTime) < 24*time.Hour {
+		return
+	}
+
+	name, _ := fleet.Self().Name()
+	h := &HostCertificates{
+		Id:           store().Id(),
+		Name:         name,
+		Updated:      time.Now(),
+		Certificates: l,
+	}
+	dat, err := json.Marshal(h)
+	if err != nil {
+		return
+	}
+	if err := store().DbSet("froach:certs:"+h.Id, dat); err != nil {
+		slog.Warn(fmt.Sprintf("[froach] failed to publish certificates: %s", err), "event", "froach:certs:publish_fail")
+		return
+	}
+	lastPublished = cmp
+	lastPublishedTime = time.Now()
+}
+
+// FleetCertificates returns the certificate summaries published by all hosts of the fleet, by
+// host id
+func FleetCertificates() (map[string]*HostCertificates, error) {
+	l, err := store().DbList("froach:certs:")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]*HostCertificates)
+	for k, v := range l {
+		h := &HostCertificates{}
+		if err := json.Unmarshal(v, h); err != nil {
+			slog.Warn(fmt.Sprintf("[froach] invalid certificates summary for %s: %s", k, err), "event", "froach:certs:invalid")
+			continue
+		}
+		res[k] = h
+	}
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package froach
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCertificatesKeyMatch(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+
+	l, err := Certificates()
+	if err != nil {
+		t.Fatalf("failed to list certificates: %s", err)
+	}
+	files := make(map[string]bool)
+	for _, c := range l {
+		files[c.File] = true
+		if !c.KeyMatch {
+			t.Errorf("%s does not match its key", c.File)
+		}
+	}
+	for _, fn := range []string{"ca.crt", "node.crt", "client.root.crt"} {
+		if !files[fn] {
+			t.Errorf("%s not listed", fn)
+		}
+	}
+}
+
+func TestFleetCertificates(t *testing.T) {
+	s := testFleet(t)
+
+	dat, err := json.Marshal(&HostCertificates{Id: "a", Name: "host-a", Certificates: []*CertificateInfo{{File: "node.crt"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DbSet("froach:certs:a", dat)
+	s.DbSet("froach:certs:b", []byte("not json"))
+	s.DbSet("froach:crl:01", []byte("{}"))
+
+	res, err := FleetCertificates()
+	if err != nil {
+		t.Fatalf("failed to list fleet certificates: %s", err)
+	}
+	if len(res) != 1 || res["a"] == nil {
+		t.Fatalf("unexpected fleet certificates %v", res)
+	}
+	if h := res["a"]; h.Name != "host-a" || len(h.Certificates) != 1 || h.Certificates[0].File != "node.crt" {
+		t.Errorf("unexpected summary %+v", h)
+	}
+}
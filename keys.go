@@ -16,26 +16,56 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
-
-	"github.com/KarpelesLab/fleet"
 )
 
+// authority is a certificate authority whose private key is shared by all hosts
+type authority struct {
+	dbKey   string // prefix of the fleet DB keys holding the PKCS#8 private key, see keyRef
+	genKey  string // fleet DB key holding the current key generation
+	subjKey string // prefix of the fleet DB keys holding the subject of imported CAs, see importedSubject
+	crtFile string // file the CA certificate is written to
+	name    string // prefix of the CA common name
+	ref     string // fleet DB key the current key was loaded from, see syncKey
+	key     crypto.Signer
+	crt     *x509.Certificate
+	trust   []*x509.Certificate  // additional CA certificates to trust, used during rotation
+	crl     *x509.RevocationList // last CRL written to disk
+}
+
 var (
 	keyLk sync.Mutex
-	caKey crypto.PrivateKey
-	caCrt *x509.Certificate
+
+	// nodeCA signs node certificates, and client certificates unless split PKI is enabled
+	nodeCA = &authority{dbKey: "froach:ca:key", genKey: "froach:ca:gen", subjKey: "froach:ca:subject", crtFile: "ca.crt", name: "CockroachDB CA"}
+
+	// clientCA signs client certificates when split PKI is enabled
+	clientCA = &authority{dbKey: "froach:ca-client:key", genKey: "froach:ca-client:gen", subjKey: "froach:ca-client:subject", crtFile: "ca-client.crt", name: "CockroachDB Client CA"}
 )
 
-func updateKey(k string, enc []byte) {
-	dec, err := x509.ParsePKCS8PrivateKey(enc)
-	if err != nil {
-		slog.Error(fmt.Sprintf("failed to parse encoded private key: %s", err), "event", "froach:update_key:parse_error")
+// authorities returns all the known authorities
+func authorities() []*authority {
+	return []*authority{nodeCA, clientCA}
+}
+
+// ready returns true if the authority has a key and a certificate. keyLk must be held.
+func (a *authority) ready() bool {
+	return a.key != nil && a.crt != nil
+}
+
+// updateClientKey is called when the key of the client CA stored in fleet DB changes
+func updateClientKey(key crypto.Signer) {
+	if key == nil {
+		// split PKI was disabled
+		if err := clientCA.clear(); err != nil {
+			slog.Error(fmt.Sprintf("cockroachdb private key removal failed: %s", err), "event", "froach:update_key:clear_fail")
+		}
 		return
 	}
 
-	err = setPrivateKey(dec)
+	err := clientCA.setPrivateKey(key)
 	if err != nil {
 		slog.Error(fmt.Sprintf("cockroachdb private key setting failed: %s", err), "event", "froach:update_key:fail")
 	}
@@ -44,37 +74,91 @@ func updateKey(k string, enc []byte) {
 // setPrivateKey will update the private key, and generate a new matching CA. The CA will
 // be different on each host (different expiration date), but will share the same CN and private
 // key, so these will work everywhere.
-func setPrivateKey(k crypto.PrivateKey) error {
+func (a *authority) setPrivateKey(key crypto.Signer) error {
 	keyLk.Lock()
 	defer keyLk.Unlock()
 
-	key, ok := k.(crypto.Signer)
-	if !ok {
-		return fmt.Errorf("unsupported private key type %T (must match crypto.Signer for x509 certificate generation)", k)
+	a.trust = nil
+	if a == nodeCA {
+		a.trust = rotationKeySet(key)
+	}
+
+	if err := a.createCA(key); err != nil {
+		return err
 	}
 
-	keyBin, err := x509.MarshalPKCS8PrivateKey(key)
+	if !nodeCA.ready() {
+		// can't issue anything without the node CA
+		return nil
+	}
+
+	_, err := checkNodeKeys()
+
+	// cockroach may already be running with the previous files
+	reloadCockroach()
+
+	return err
+}
+
+// clear forgets the authority key and removes its files, as well as the certificates that
+// depend on it
+func (a *authority) clear() error {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	if a.key == nil {
+		return nil
+	}
+	a.key = nil
+	a.crt = nil
+	a.crl = nil
+	os.Remove(filepath.Join(basePath(), a.crtFile))
+	os.Remove(filepath.Join(basePath(), a.crlFile()))
+
+	if !nodeCA.ready() {
+		return nil
+	}
+	_, err := checkNodeKeys()
+	reloadCockroach()
+	return err
+}
+
+// createCA generates a new CA certificate for the given key and writes it to disk
+func (a *authority) createCA(key crypto.Signer) error {
+	caCrt, err := a.newCACert(key)
 	if err != nil {
-		return fmt.Errorf("failed to marshal private key: %w", err)
+		return err
 	}
 
+	a.key = key
+	a.crt = caCrt
+
+	return a.writeCrt()
+}
+
+// newCACert generates a self-signed CA certificate for the given key
+func (a *authority) newCACert(key crypto.Signer) (*x509.Certificate, error) {
 	// generate pubkey hash & put it into the common name to guarantee we're not using the wrong key
 	// SubjectKeyId will also be included in the CA, but that's sha1 hash
 	pubKey := key.Public()
-	pubKeyBin, err := x509.MarshalPKIXPublicKey(pubKey)
+	pubHash, err := pubKeyHash(pubKey)
 	if err != nil {
-		return fmt.Errorf("failed to marshal PKIX: %w", err)
+		return nil, err
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
 	}
-	pubHash := sha256.Sum256(pubKeyBin)
 
 	now := time.Now()
 
-	caSubject := pkix.Name{CommonName: "CockroachDB CA #" + base64.RawURLEncoding.EncodeToString(pubHash[:])}
+	caSubject := pkix.Name{CommonName: a.name + " #" + pubHash}
 
 	caCrtTpl := &x509.Certificate{
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		SerialNumber:          big.NewInt(1),
+		SerialNumber:          serial,
 		Issuer:                caSubject,
 		Subject:               caSubject,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
@@ -82,20 +166,57 @@ func setPrivateKey(k crypto.PrivateKey) error {
 		NotBefore:             now,
 		NotAfter:              now.Add(10 * 365 * 24 * time.Hour), // + ~10 years
 	}
+	if raw := a.importedSubject(pubHash); raw != nil {
+		// keep the subject of an imported CA so certificates it issued before remain valid
+		caCrtTpl.RawSubject = raw
+	}
 
 	caCrtBin, err := x509.CreateCertificate(rand.Reader, caCrtTpl, caCrtTpl, pubKey, key)
 	if err != nil {
-		return fmt.Errorf("failed to create CA crt: %w", err)
+		return nil, fmt.Errorf("failed to create CA crt: %w", err)
 	}
 
 	// func ParseCertificate(der []byte) (*Certificate, error)
 	caCrtParsed, err := x509.ParseCertificate(caCrtBin)
 	if err != nil {
-		return fmt.Errorf("failed to parse freshly generated CA: %w", err)
+		return nil, fmt.Errorf("failed to parse freshly generated CA: %w", err)
+	}
+
+	return caCrtParsed, nil
+}
+
+// pubKeyHash returns a hash of the given public key, which is the same on all hosts
+func pubKeyHash(pubKey crypto.PublicKey) (string, error) {
+	pubKeyBin, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal PKIX: %w", err)
 	}
+	pubHash := sha256.Sum256(pubKeyBin)
+	return base64.RawURLEncoding.EncodeToString(pubHash[:]), nil
+}
 
-	caKeyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBin})
-	caCrtPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCrtBin})
+// issuers returns the CA certificates accepted for certificates issued by the authority: its own,
+// and during a rotation of the node CA, the new CA. keyLk must be held.
+func (a *authority) issuers() []*x509.Certificate {
+	res := []*x509.Certificate{a.crt}
+	if a == nodeCA && nextCA.crt != nil && !nextCA.crt.Equal(a.crt) {
+		res = append(res, nextCA.crt)
+	}
+	return res
+}
+
+// crtPem returns the CA certificate followed by any additional trusted CA in PEM format
+func (a *authority) crtPem() []byte {
+	var res []byte
+	for _, crt := range append([]*x509.Certificate{a.crt}, a.trust...) {
+		res = append(res, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})...)
+	}
+	return res
+}
+
+// writeCrt writes the CA certificate to disk, followed by any additional trusted CA
+func (a *authority) writeCrt() error {
+	caCrtPem := a.crtPem()
 
 	// write files
 	// certificates stored in ~/.config/froach and data in ~/.cache/froach
@@ -104,65 +225,181 @@ func setPrivateKey(k crypto.PrivateKey) error {
 
 	slog.Debug(fmt.Sprintf("[froach] writing cockroachdb keys to %s", p), "event", "froach:key:write_dir")
 
-	// TODO we write ca.key for now, we should not in the future
-	err = os.WriteFile(filepath.Join(p, "ca.key"), caKeyPem, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write ca.key: %w", err)
+	// the CA key is only kept in memory, remove any ca.key left by previous versions
+	if err := os.Remove(filepath.Join(p, "ca.key")); err == nil {
+		slog.Info(fmt.Sprintf("[froach] removed legacy CA private key from %s", p), "event", "froach:key:legacy_removed")
 	}
-	err = os.WriteFile(filepath.Join(p, "ca.crt"), caCrtPem, 0644)
+
+	err := os.WriteFile(filepath.Join(p, a.crtFile), caCrtPem, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to write ca.crt: %w", err)
+		return fmt.Errorf("failed to write %s: %w", a.crtFile, err)
 	}
 
-	caKey = key
-	caCrt = caCrtParsed
+	return nil
+}
 
-	return checkNodeKeys()
+// sign signs a certificate with the authority. keyLk must be held.
+func (a *authority) sign(tpl *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	if !a.ready() {
+		return nil, ErrNoCA
+	}
+	if UseOCSP && !tpl.IsCA && tpl.OCSPServer == nil {
+		// let cockroach check revocation against the local OCSP responder
+		t := *tpl
+		t.OCSPServer = []string{ocspURL}
+		tpl = &t
+	}
+	return x509.CreateCertificate(rand.Reader, tpl, a.crt, pub, a.key)
+}
+
+// clientAuthority returns the authority used to sign client certificates. keyLk must be held.
+func clientAuthority() *authority {
+	if clientCA.ready() {
+		return clientCA
+	}
+	return nodeCA
+}
+
+// certSpec describes a certificate and its private key as managed by froach
+type certSpec struct {
+	ca       *authority
+	dir      string // directory the files are stored in
+	crtFile  string
+	keyFile  string
+	cn       string
+	altNames []string
+	ttl      time.Duration
+}
+
+// nodeCertSpecs returns the certificates cockroach needs to run a node. keyLk must be held.
+func nodeCertSpecs() []*certSpec {
+	p := basePath()
+	res := []*certSpec{
+		{ca: nodeCA, dir: p, crtFile: "node.crt", keyFile: "node.key", cn: "node", altNames: nodeAltNames(), ttl: 365 * 24 * time.Hour},
+		{ca: clientAuthority(), dir: p, crtFile: "client.root.crt", keyFile: "client.root.key", cn: "root", ttl: 365 * 24 * time.Hour},
+	}
+	if clientCA.ready() {
+		// split PKI: nodes need a client certificate from the client CA to connect to each
+		// other, and the DB Console gets its own certificate
+		res = append(res,
+			&certSpec{ca: clientCA, dir: p, crtFile: "client.node.crt", keyFile: "client.node.key", cn: "node", ttl: 365 * 24 * time.Hour},
+			&certSpec{ca: nodeCA, dir: p, crtFile: "ui.crt", keyFile: "ui.key", cn: "ui", altNames: nodeAltNames(), ttl: 365 * 24 * time.Hour},
+		)
+	}
+	return res
 }
 
+// splitCertFiles lists files only used with split PKI
+var splitCertFiles = []string{"client.node.crt", "client.node.key", "ui.crt", "ui.key"}
+
 // checkNodeKeys checks if node.pem and user.root.pem exist, are not expiring and are signed by the correct CA. If not, these are re-generated.
-func checkNodeKeys() error {
-	if err := checkOrCreateKey("node.crt", "node.key", "node", fleet.Self().AltNames()...); err != nil {
-		return err
+// It returns true if any file was re-generated.
+func checkNodeKeys() (bool, error) {
+	updated := false
+	if !clientCA.ready() {
+		// remove files from split PKI so cockroach doesn't try to use them
+		for _, fn := range splitCertFiles {
+			if os.Remove(filepath.Join(basePath(), fn)) == nil {
+				updated = true
+			}
+		}
 	}
-	if err := checkOrCreateKey("client.root.crt", "client.root.key", "root"); err != nil {
-		return err
+	for _, spec := range nodeCertSpecs() {
+		upd, err := spec.check()
+		if err != nil {
+			return updated, err
+		}
+		updated = updated || upd
 	}
-	return nil
+	return updated, nil
 }
 
-func checkOrCreateKey(crtFile, keyFile, cn string, altNames ...string) error {
-	p := basePath()
+// path returns the path to the certificate file
+func (s *certSpec) path() string {
+	return filepath.Join(s.dir, s.crtFile)
+}
 
-	_, err := os.Stat(filepath.Join(p, crtFile))
-	_, err2 := os.Stat(filepath.Join(p, keyFile))
+// check checks the certificate and creates a new one if needed. It returns true if a new
+// certificate was created.
+func (s *certSpec) check() (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, s.crtFile))
+	_, err2 := os.Stat(filepath.Join(s.dir, s.keyFile))
 	// either file is missing → create
 	if err != nil || err2 != nil {
-		return createKey(crtFile, keyFile, cn, altNames...)
+		return true, s.create()
 	}
 
-	crt, err := readCertificateFile(filepath.Join(p, crtFile))
+	crt, err := readCertificateFile(filepath.Join(s.dir, s.crtFile))
+	if err != nil {
+		return s.reissue(fmt.Sprintf("failed to read certificate: %s", err))
+	}
+	key, err := readPrivateKeyFile(filepath.Join(s.dir, s.keyFile))
 	if err != nil {
-		return createKey(crtFile, keyFile, cn, altNames...)
+		return s.reissue(fmt.Sprintf("failed to read private key: %s", err))
 	}
 
-	// check if crt.Issuer == caCrt.Subject. Only check commonname for now
-	if crt.Issuer.CommonName != caCrt.Subject.CommonName {
-		return createKey(crtFile, keyFile, cn, altNames...)
+	if serialLeaksKey(crt, key) {
+		// certificates created by older versions embedded the private key in the serial number
+		slog.Error(fmt.Sprintf("[froach] SECURITY: certificate %s exposes its private key in its serial number, reissuing now", s.path()), "event", "froach:cert:key_leak", "file", s.path())
+		return true, s.create()
 	}
 
-	// assume ok
-	return nil
+	if isRevoked(crt) {
+		return s.reissue("certificate was revoked")
+	}
+
+	// certificates already reissued with the new CA during a rotation are kept when froach
+	// restarts before the rotation completes
+	if reason := certProblem(crt, key, s.ca.issuers(), s.cn, s.altNames); reason != "" {
+		return s.reissue(reason)
+	}
+
+	if UseOCSP && !slices.Contains(crt.OCSPServer, ocspURL) {
+		return s.reissue("certificate does not list the OCSP responder")
+	}
+
+	if expiring(s.path(), crt) {
+		slog.Info(fmt.Sprintf("[froach] renewing %s expiring on %s", s.path(), crt.NotAfter), "event", "froach:cert:renew")
+		return true, s.create()
+	}
+
+	// all good
+	return false, nil
+}
+
+// reissue logs why a certificate is being replaced and creates a new one
+func (s *certSpec) reissue(reason string) (bool, error) {
+	slog.Warn(fmt.Sprintf("[froach] reissuing %s: %s", s.path(), reason), "event", "froach:cert:reissue", "file", s.path())
+	return true, s.create()
+}
+
+// randomSerial returns a random 128 bits certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
 }
 
-func createKey(crtFile, keyFile, cn string, altNames ...string) error {
+// issue generates a new private key and a matching certificate signed by the CA, and returns
+// both in PEM format
+func (s *certSpec) issue() (keyPem, crtPem []byte, err error) {
 	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	keyBin, err := x509.MarshalPKCS8PrivateKey(newKey)
 	if err != nil {
-		return fmt.Errorf("failed to marshal private key: %w", err)
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.ca.ready() {
+		return nil, nil, ErrNoCA
 	}
 
 	// create key & CA-signed CA
@@ -170,16 +407,16 @@ func createKey(crtFile, keyFile, cn string, altNames ...string) error {
 	crtTpl := &x509.Certificate{
 		BasicConstraintsValid: true,
 		IsCA:                  false,
-		SerialNumber:          new(big.Int).SetBytes(keyBin),
-		Issuer:                caCrt.Subject,
-		Subject:               pkix.Name{CommonName: cn},
+		SerialNumber:          serial,
+		Issuer:                s.ca.crt.Subject,
+		Subject:               pkix.Name{CommonName: s.cn},
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyEncipherment,
 		NotBefore:             now,
-		NotAfter:              now.Add(365 * 24 * time.Hour),
+		NotAfter:              now.Add(s.ttl),
 	}
 
 	// add altnames
-	for _, a := range altNames {
+	for _, a := range s.altNames {
 		if ip := net.ParseIP(a); ip != nil {
 			crtTpl.IPAddresses = append(crtTpl.IPAddresses, ip)
 		} else {
@@ -187,27 +424,34 @@ func createKey(crtFile, keyFile, cn string, altNames ...string) error {
 		}
 	}
 
-	crtBin, err := x509.CreateCertificate(rand.Reader, crtTpl, caCrt, newKey.Public(), caKey)
+	crtBin, err := s.ca.sign(crtTpl, newKey.Public())
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBin})
-	crtPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crtBin})
+	keyPem = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBin})
+	crtPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crtBin})
+	return keyPem, crtPem, nil
+}
+
+// create issues a new certificate and writes it to disk
+func (s *certSpec) create() error {
+	keyPem, crtPem, err := s.issue()
+	if err != nil {
+		return err
+	}
 
 	// write files
 	// certificates stored in ~/.config/froach and data in ~/.cache/froach
-	p := basePath()
-	os.MkdirAll(p, 0755)
+	os.MkdirAll(s.dir, 0755)
 
-	// TODO we write ca.key for now, we should not in the future
-	err = os.WriteFile(filepath.Join(p, keyFile), keyPem, 0600)
+	err = os.WriteFile(filepath.Join(s.dir, s.keyFile), keyPem, 0600)
 	if err != nil {
-		return fmt.Errorf("failed to write %s: %w", keyFile, err)
+		return fmt.Errorf("failed to write %s: %w", s.keyFile, err)
 	}
-	err = os.WriteFile(filepath.Join(p, crtFile), crtPem, 0644)
+	err = os.WriteFile(filepath.Join(s.dir, s.crtFile), crtPem, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to write %s: %w", crtFile, err)
+		return fmt.Errorf("failed to write %s: %w", s.crtFile, err)
 	}
 	return nil
 }
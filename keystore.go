@@ -0,0 +1,160 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Authority keys are stored in fleet DB by generation: genKey holds a random generation id and
+// the key itself is stored under dbKey:<id>. Replacing a key means storing it under a new
+// generation, after which the key of the previous generation is deleted. Removing a key sets the
+// generation to keyRemoved.
+//
+// The first key of an authority is stored under dbKey! while genKey doesn't exist, so hosts
+// starting at the same time agree on the same key. This is also where previous versions stored
+// the key. fleet DB keeps the first value written to keys ending with "!" and these can't be
+// deleted, so this key remains in fleet DB after it was replaced.
+const keyRemoved = "-"
+
+// syncLk ensures keys are loaded in the order these were stored
+var syncLk sync.Mutex
+
+// keyRef returns the fleet DB key holding the current private key of the authority, or an empty
+// string if it has no key
+func (a *authority) keyRef() (string, error) {
+	gen, err := store().DbGet(a.genKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := store().DbGet(a.dbKey + "!"); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return "", nil
+			}
+			return "", err
+		}
+		return a.dbKey + "!", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if string(gen) == keyRemoved {
+		return "", nil
+	}
+	return a.dbKey + ":" + string(gen), nil
+}
+
+// loadKey returns the current private key of the authority and the fleet DB key it was read
+// from, or a nil key if the authority has no key
+func (a *authority) loadKey() (crypto.Signer, string, error) {
+	ref, err := a.keyRef()
+	if err != nil || ref == "" {
+		return nil, "", err
+	}
+	enc, err := store().DbGet(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := parseSigner(enc)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, ref, nil
+}
+
+// storeKey stores the key as a new generation, replacing the key of the authority on all hosts
+func (a *authority) storeKey(key crypto.Signer) error {
+	kData, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	prev, err := a.keyRef()
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	gen := hex.EncodeToString(id)
+
+	// the key must be stored before its generation, see syncKey
+	if err := store().DbSet(a.dbKey+":"+gen, kData); err != nil {
+		return err
+	}
+	if err := store().DbSet(a.genKey, []byte(gen)); err != nil {
+		return err
+	}
+	return dropKey(prev)
+}
+
+// removeKey removes the key of the authority on all hosts
+func (a *authority) removeKey() error {
+	prev, err := a.keyRef()
+	if err != nil {
+		return err
+	}
+	if err := store().DbSet(a.genKey, []byte(keyRemoved)); err != nil {
+		return err
+	}
+	return dropKey(prev)
+}
+
+// dropKey deletes a key that was replaced from fleet DB, unless it is the first key which can't
+// be deleted
+func dropKey(ref string) error {
+	if ref == "" || strings.HasSuffix(ref, "!") {
+		return nil
+	}
+	return store().DbDelete(ref)
+}
+
+// watchKey calls syncKey each time the key of the authority changes in fleet DB
+func (a *authority) watchKey(set func(crypto.Signer)) {
+	cb := func(string, []byte) {
+		a.syncKey(set)
+	}
+	store().DbWatch(a.genKey, cb)
+	store().DbWatch(a.dbKey+"!", cb)
+}
+
+// syncKey loads the key of the authority from fleet DB and calls set with it if it changed since
+// the last call, or with nil if the key was removed
+func (a *authority) syncKey(set func(crypto.Signer)) {
+	syncLk.Lock()
+	defer syncLk.Unlock()
+
+	key, ref, err := a.loadKey()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// generation received before its key, syncKeys will try again
+			return
+		}
+		slog.Error(fmt.Sprintf("[froach] failed to load %s key: %s", a.crtFile, err), "event", "froach:key:load_fail")
+		return
+	}
+
+	keyLk.Lock()
+	changed := ref != a.ref
+	a.ref = ref
+	keyLk.Unlock()
+
+	if changed {
+		set(key)
+	}
+}
+
+// syncKeys loads the keys stored in fleet DB again, in case a generation was received before its
+// key
+func syncKeys() {
+	if b, ok := getCABackend().(*fleetDBBackend); ok {
+		b.sync()
+	}
+	clientCA.syncKey(updateClientKey)
+	nextCA.syncKey(updateNextKey)
+}
@@ -0,0 +1,243 @@
+package froach
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestCluster is a multi-node local cluster started by LocalTestCluster
+type TestCluster struct {
+	exe     string
+	logSink func(string)
+	dir     string // temporary directory holding the stores of the nodes
+	nodes   []*testNode
+	lk      sync.Mutex
+}
+
+type testNode struct {
+	args    []string
+	sqlAddr string
+	proc    *testServer // nil if the node is stopped
+}
+
+// testClusterAttempts is how many times LocalTestCluster tries to start the cluster when a port
+// it picked was taken in the meantime
+const testClusterAttempts = 3
+
+// LocalTestCluster starts a local cluster of n nodes on free ports, suitable for testing how
+// code behaves when nodes fail. Unlike LocalTestServer, nodes store their data on disk in a
+// temporary directory so these can be restarted. Stop must be called once done.
+func LocalTestCluster(n int, opts TestServerOptions) (*TestCluster, error) {
+	if n < 1 {
+		return nil, errors.New("a cluster needs at least one node")
+	}
+
+	p, err := opts.exe()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		c, err := startTestCluster(p, n, opts)
+		if err == nil || attempt == testClusterAttempts || !strings.Contains(err.Error(), "address already in use") {
+			return c, err
+		}
+		log.Printf("[froach] test cluster port taken, trying other ports: %s", err)
+	}
+}
+
+// startTestCluster starts a cluster of n nodes running cockroach binary p
+func startTestCluster(p string, n int, opts TestServerOptions) (*TestCluster, error) {
+	// nodes need to know each other's address before starting, so we can't use port 0 here, and
+	// another process may take one of these ports before the nodes listen on it
+	ports, err := freePorts(n * 3)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "froach-cluster-")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TestCluster{exe: p, dir: dir, logSink: opts.LogSink}
+
+	var join []string
+	for i := 0; i < n; i++ {
+		join = append(join, fmt.Sprintf("localhost:%d", ports[i*3]))
+	}
+
+	for i := 0; i < n; i++ {
+		args := []string{
+			"start",
+			"--insecure",
+			"--store=" + filepath.Join(dir, fmt.Sprintf("node%d", i)),
+			"--cache=64MiB",
+			"--max-sql-memory=128MiB",
+			"--listen-addr=" + join[i],
+			fmt.Sprintf("--sql-addr=localhost:%d", ports[i*3+1]),
+			fmt.Sprintf("--http-addr=localhost:%d", ports[i*3+2]),
+			"--join=" + strings.Join(join, ","),
+		}
+		args = append(args, spatialArgs(spatialLibDir(p))...)
+		args = append(args, opts.ExtraFlags...)
+
+		c.nodes = append(c.nodes, &testNode{args: args, sqlAddr: fmt.Sprintf("localhost:%d", ports[i*3+1])})
+	}
+
+	for _, node := range c.nodes {
+		node.proc, err = startTestProcess(p, node.args, opts.LogSink)
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+
+	if err := c.init(join[0]); err != nil {
+		c.Stop()
+		return nil, err
+	}
+
+	for i, node := range c.nodes {
+		if _, err := node.proc.waitReady(); err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+	}
+
+	if err := opts.setup(c.DSN(0)); err != nil {
+		c.Stop()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// freePorts returns n distinct TCP ports available on localhost
+func freePorts(n int) ([]int, error) {
+	var res []int
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return nil, err
+		}
+		// keep listening until we have all ports so we don't get the same port twice
+		defer l.Close()
+		res = append(res, l.Addr().(*net.TCPAddr).Port)
+	}
+	return res, nil
+}
+
+// init runs cockroach init against the given node, retrying until it is ready to accept it
+func (c *TestCluster) init(host string) error {
+	var err error
+	for i := 0; i < 120; i++ {
+		for n, node := range c.nodes {
+			select {
+			case <-node.proc.done:
+				return node.proc.startupError(fmt.Errorf("node %d ended before the cluster was initialized: %w", n, node.proc.exitErr))
+			default:
+			}
+		}
+
+		var out []byte
+		out, err = exec.Command(c.exe, "init", "--insecure", "--host="+host).CombinedOutput()
+		if err == nil || strings.Contains(string(out), "already been initialized") {
+			return nil
+		}
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		time.Sleep(time.Second / 2)
+	}
+	return fmt.Errorf("failed to initialize cluster: %w", err)
+}
+
+// DSN returns the DSN to connect to node i
+func (c *TestCluster) DSN(i int) string {
+	return "postgresql://root@" + c.nodes[i].sqlAddr + "/defaultdb?sslmode=disable"
+}
+
+// ClusterDSN returns a DSN listing all the nodes, so clients can connect to any node available
+func (c *TestCluster) ClusterDSN() string {
+	var hosts []string
+	for _, node := range c.nodes {
+		hosts = append(hosts, node.sqlAddr)
+	}
+	return "postgresql://root@" + strings.Join(hosts, ",") + "/defaultdb?sslmode=disable"
+}
+
+// StopNode gracefully stops node i, letting it drain its connections and leases
+func (c *TestCluster) StopNode(i int) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	node := c.nodes[i]
+	if node.proc == nil {
+		return nil
+	}
+	err := node.proc.terminate(time.Minute)
+	node.proc = nil
+	return err
+}
+
+// KillNode kills node i without giving it a chance to shut down properly
+func (c *TestCluster) KillNode(i int) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	node := c.nodes[i]
+	if node.proc == nil {
+		return nil
+	}
+	err := node.proc.stop()
+	node.proc = nil
+	return err
+}
+
+// RestartNode starts node i again after StopNode or KillNode, and waits for it to be ready
+func (c *TestCluster) RestartNode(i int) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	node := c.nodes[i]
+	if node.proc != nil {
+		return fmt.Errorf("node %d is already running", i)
+	}
+
+	proc, err := startTestProcess(c.exe, node.args, c.logSink)
+	if err != nil {
+		return err
+	}
+	if _, err := proc.waitReady(); err != nil {
+		proc.stop()
+		return fmt.Errorf("node %d: %w", i, err)
+	}
+	node.proc = proc
+	return nil
+}
+
+// Stop kills all the nodes and removes their data
+func (c *TestCluster) Stop() error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	var errs []error
+	for i, node := range c.nodes {
+		if node.proc == nil {
+			continue
+		}
+		if err := node.proc.stop(); err != nil {
+			errs = append(errs, fmt.Errorf("node %d: %w", i, err))
+		}
+		node.proc = nil
+	}
+	os.RemoveAll(c.dir)
+	return errors.Join(errs...)
+}
@@ -6,25 +6,65 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
+	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type testServer struct {
-	cmd   *exec.Cmd // allows tracking cmd.Process if needed
-	ended bool
+	cmd     *exec.Cmd     // allows tracking cmd.Process if needed
+	dir     string        // temporary directory holding the listening url file
+	ready   chan struct{} // closed once cockroach reports it started
+	done    chan struct{} // closed once the process has ended
+	exitErr error         // error returned by cmd.Wait, set before done is closed
+
+	outDone   chan struct{} // closed once stdout and stderr have been fully read
+	readyOnce sync.Once
+	tail      []string // last lines of stderr, protected by tailLk
+	tailLk    sync.Mutex
+	logSink   func(string) // receives stderr lines if set
 }
 
+// testServerTail is the number of stderr lines included in errors when startup fails
+const testServerTail = 20
+
+// TestServerOptions are options for local test servers and clusters. StoreSize, OnDisk,
+// External and Shared only apply to LocalTestServerWithOptions.
+type TestServerOptions struct {
+	Version         string            // cockroach version (such as v23.2.5), defaults to the latest
+	StoreSize       string            // size of the store, defaults to 50% (of memory) for in-memory stores
+	OnDisk          bool              // store data in a temporary directory rather than in memory
+	ExtraFlags      []string          // additional flags passed to cockroach start
+	ClusterSettings map[string]string // cluster settings applied once ready, values are SQL expressions (such as true or '10s')
+	LogSink         func(line string) // receives each line cockroach writes to stderr, by default errors are logged with the log package
+	External        string            // DSN of an existing server to use instead of starting one, see TestDSNEnv
+	Shared          bool              // share the server with other test binaries using the same options, see TestSharedEnv
+	Schema          fs.FS             // .sql files applied in lexical order to the default database once the server is ready
+	Seed            fs.FS             // .sql files applied in lexical order after Schema
+}
+
+// TestDSNEnv is the environment variable that can be set to the DSN of an existing server for
+// LocalTestServer to use instead of starting its own
+const TestDSNEnv = "FROACH_TEST_DSN"
+
 var (
-	testDsn   string
-	testErr   error
-	testStart sync.Once
+	testProc *testServer
+	testExt  *TestDB      // database created on an external or shared server
+	testRel  func() error // releases the shared server
+	testDsn  string
+	testErr  error
+	testLk   sync.Mutex
 )
 
 // LocalTestServer returns a dsn that can be used for local tests, especially suitable for Go unit tests
@@ -32,96 +72,424 @@ var (
 // This will start a local database with in-memory storage that will shutdown at the end of the tests.
 // The database will always start in an empty state, and all data written to it will be lost once the
 // execution completes.
+//
+// Use RunTests in TestMain to make sure the server is stopped once tests complete.
+//
+// If FROACH_TEST_DSN is set, no server is started and a database is instead created for the
+// current test binary on the given server, and dropped by StopLocalTestServer. Similarly if
+// FROACH_TEST_SHARED is set, test binaries share a server started by the first one.
 func LocalTestServer() (string, error) {
-	testStart.Do(func() {
-		testDsn, testErr = launchLocalTestServer()
-	})
+	return LocalTestServerWithOptions(TestServerOptions{})
+}
+
+// LocalTestServerWithOptions is similar to LocalTestServer but accepts options. Options are only
+// used when the server is started, so this should be called from TestMain before any test calls
+// LocalTestServer or NewTestDB.
+func LocalTestServerWithOptions(opts TestServerOptions) (string, error) {
+	testLk.Lock()
+	defer testLk.Unlock()
+
+	if testDsn == "" && testErr == nil {
+		testDsn, testErr = startLocalTestServer(opts)
+	}
 
 	return testDsn, testErr
 }
 
-func launchLocalTestServer() (string, error) {
+// startLocalTestServer starts the test server or attaches to an external server. testLk must be held.
+func startLocalTestServer(opts TestServerOptions) (string, error) {
+	ext := opts.External
+	if ext == "" {
+		ext = os.Getenv(TestDSNEnv)
+	}
+
+	if ext == "" && (opts.Shared || os.Getenv(TestSharedEnv) != "") {
+		srv, release, err := attachSharedTestServer(opts)
+		if err == nil {
+			ext, testRel = srv, release
+			opts.ClusterSettings = nil // applied by the supervisor
+		} else {
+			log.Printf("[froach] shared test server unavailable, starting a dedicated one: %s", err)
+		}
+	}
+
+	var dsn string
+	if ext != "" {
+		db, err := createTestDB(ext, testDBName(filepath.Base(os.Args[0])), TestDBOptions{})
+		if err != nil {
+			stopLocalTestServer()
+			return "", err
+		}
+		testExt, dsn = db, db.DSN
+	} else {
+		pi, d, err := launchLocalTestServer(opts)
+		if err != nil {
+			return "", err
+		}
+		testProc, dsn = pi, d
+	}
+
+	if err := opts.setup(dsn); err != nil {
+		stopLocalTestServer()
+		return "", err
+	}
+	return dsn, nil
+}
+
+// StopLocalTestServer stops the server started by LocalTestServer, if any. Calling
+// LocalTestServer again will start a new server.
+func StopLocalTestServer() error {
+	testLk.Lock()
+	defer testLk.Unlock()
+
+	return stopLocalTestServer()
+}
+
+// stopLocalTestServer stops the test server. testLk must be held.
+func stopLocalTestServer() error {
+	pi, ext, rel := testProc, testExt, testRel
+	testProc, testExt, testRel, testDsn, testErr = nil, nil, nil, "", nil
+
+	// templates must be dropped while the server is still running
+	errs := []error{dropTestTemplates()}
+	if ext != nil {
+		errs = append(errs, ext.drop())
+	}
+	if rel != nil {
+		// the shared server stops by itself once it has no users left
+		errs = append(errs, rel())
+	}
+	if pi != nil {
+		errs = append(errs, pi.stop())
+	}
+	return errors.Join(errs...)
+}
+
+// RunTests runs the tests, stops the local test server and exits. It is meant to be called from
+// TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		froach.RunTests(m)
+//	}
+func RunTests(m *testing.M) {
+	superviseIfRequested()
+	runningTests = true
+
+	code := m.Run()
+	if err := StopLocalTestServer(); err != nil {
+		log.Printf("[froach] failed to stop test server: %s", err)
+	}
+	os.Exit(code)
+}
+
+// exe returns the cockroach executable for the requested version
+func (o *TestServerOptions) exe() (string, error) {
+	if o.Version != "" {
+		return ExeVersion(o.Version)
+	}
+	return Exe()
+}
+
+// setup applies cluster settings and fixtures once the server is ready
+func (o *TestServerOptions) setup(dsn string) error {
+	if len(o.ClusterSettings) > 0 {
+		var queries []string
+		for k, v := range o.ClusterSettings {
+			queries = append(queries, fmt.Sprintf("SET CLUSTER SETTING %s = %s", k, v))
+		}
+		if err := execSQL(dsn, queries...); err != nil {
+			return err
+		}
+	}
+
+	if err := applySQL(dsn, o.Schema, o.Seed); err != nil {
+		return fmt.Errorf("failed to apply fixtures: %w", err)
+	}
+	return nil
+}
+
+func launchLocalTestServer(opts TestServerOptions) (*testServer, string, error) {
 	// first, let's locate cockroach
-	p, err := Exe()
+	p, err := opts.exe()
 	if err != nil {
 		// cockroach not found
-		return "", err
+		return nil, "", err
+	}
+
+	storeSpec := "type=mem,size=50%"
+	var storeDir string
+	if opts.OnDisk {
+		storeDir, err = os.MkdirTemp("", "froach-store-")
+		if err != nil {
+			return nil, "", err
+		}
+		storeSpec = "path=" + storeDir
+		if opts.StoreSize != "" {
+			storeSpec += ",size=" + opts.StoreSize
+		}
+	} else if opts.StoreSize != "" {
+		storeSpec = "type=mem,size=" + opts.StoreSize
 	}
 
 	// prepare to run it
-	// TODO: allow configuration of ports? Use random ports?
-	cmd := exec.Command(p, "start-single-node", "--insecure", "--store=type=mem,size=50%", "--listen-addr=localhost:26259", "--sql-addr=localhost:26258", "--http-addr", "localhost:28081")
+	args := []string{
+		"start-single-node",
+		"--insecure",
+		"--store=" + storeSpec,
+		"--listen-addr=localhost:0",
+		"--sql-addr=localhost:0",
+		"--http-addr=localhost:0",
+	}
+	args = append(args, spatialArgs(spatialLibDir(p))...)
+	args = append(args, opts.ExtraFlags...)
 
-	cmd.Stdout = os.Stdout
-	stderr, err := cmd.StderrPipe()
+	pi, err := startTestProcess(p, args, opts.LogSink)
 	if err != nil {
-		// unlikely
-		return "", err
+		if storeDir != "" {
+			os.RemoveAll(storeDir)
+		}
+		return nil, "", err
+	}
+	if storeDir != "" {
+		go func() {
+			<-pi.done
+			os.RemoveAll(storeDir)
+		}()
 	}
 
-	pi := &testServer{
-		cmd: cmd,
+	dsn, err := pi.waitReady()
+	if err != nil {
+		pi.stop()
+		return nil, "", err
+	}
+
+	if err := checkSpatial(dsn); err != nil {
+		log.Printf("[froach] test server: %s", err)
 	}
+	return pi, dsn, nil
+}
+
+// startTestProcess starts cockroach with the given arguments. --listening-url-file is added so
+// the server can listen on random ports, see waitReady.
+func startTestProcess(exe string, args []string, logSink func(string)) (*testServer, error) {
+	// cockroach writes the SQL url to this file once listening
+	dir, err := os.MkdirTemp("", "froach-test-")
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(slices.Clone(args),
+		"--listening-url-file="+filepath.Join(dir, "url"),
+		"--external-io-dir="+filepath.Join(dir, "extern"), // for nodelocal backups, see testTemplate
+	)
+	cmd := exec.Command(exe, args...)
+	setTestProcAttr(cmd)
 
-	go pi.readStdErr(stderr)
+	// using io.Pipe rather than cmd.StderrPipe lets cmd.Wait return only once all the output has
+	// been read, so the tail of stderr is complete when done is closed
+	outR, outW := io.Pipe()
+	errR, errW := io.Pipe()
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+
+	pi := &testServer{
+		cmd:     cmd,
+		dir:     dir,
+		logSink: logSink,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		outDone: make(chan struct{}),
+	}
 
 	err = cmd.Start()
 	if err != nil {
-		return "", fmt.Errorf("failed to start cockroach: %w", err)
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start cockroach: %w", err)
 	}
 
-	go pi.wait()
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); pi.readStdOut(outR) }()
+		go func() { defer wg.Done(); pi.readStdErr(errR) }()
+		wg.Wait()
+		close(pi.outDone)
+	}()
+	go pi.wait(outW, errW)
 
-	dsn := "postgresql://root@localhost:26258/defaultdb?sslmode=disable"
+	return pi, nil
+}
 
-	// let's try to connect
-	for i := 0; i < 120; i++ {
-		err = pi.attemptConnect(dsn)
-		if err == nil {
-			// success!
-			return dsn, nil
-		}
-		// make sure process is still running
-		if pi.ended {
-			return "", errors.New("cockroach db ended before we could connect to it")
-		}
+// waitReady waits until cockroach reports it started on stdout, which it does after writing the
+// listening url file, then connects once to check the server accepts connections and returns its
+// DSN.
+func (pi *testServer) waitReady() (string, error) {
+	timeout := time.NewTimer(time.Minute)
+	defer timeout.Stop()
 
-		time.Sleep(time.Second / 2)
+	select {
+	case <-pi.ready:
+	case <-pi.done:
+		return "", pi.startupError(fmt.Errorf("cockroach db ended before we could connect to it: %w", pi.exitErr))
+	case <-timeout.C:
+		return "", pi.startupError(errors.New("cockroach did not report being ready"))
 	}
 
-	return "", fmt.Errorf("failed to connect to server: %w", err)
+	dsn, err := pi.listeningURL()
+	if err != nil {
+		return "", pi.startupError(fmt.Errorf("failed to read listening url: %w", err))
+	}
+	if err := pi.attemptConnect(dsn); err != nil {
+		return "", pi.startupError(fmt.Errorf("failed to connect to server: %w", err))
+	}
+	return dsn, nil
+}
+
+// startupError adds the last lines cockroach wrote to stderr to err
+func (pi *testServer) startupError(err error) error {
+	pi.tailLk.Lock()
+	defer pi.tailLk.Unlock()
+
+	if len(pi.tail) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, strings.Join(pi.tail, "\n"))
+}
+
+// readStdOut forwards cockroach stdout to ours, and closes ready once cockroach reports that it
+// started
+func (pi *testServer) readStdOut(pipe io.Reader) {
+	buf := bufio.NewReader(pipe)
+	for {
+		lin, err := buf.ReadString('\n')
+		if len(lin) > 0 {
+			os.Stdout.WriteString(lin)
+			if strings.HasPrefix(lin, "CockroachDB node starting") {
+				pi.readyOnce.Do(func() { close(pi.ready) })
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
 }
 
 // readStdErr can be run in a separate thread and will log any error happening
-// with cockroach that isn't an Info or a Warning
-func (pi *testServer) readStdErr(pipe io.ReadCloser) {
+// with cockroach that isn't an Info or a Warning, unless a log sink was set
+func (pi *testServer) readStdErr(pipe io.Reader) {
 	buf := bufio.NewReader(pipe)
 	for {
 		lin, err := buf.ReadString('\n')
+		pi.stdErrLine(strings.TrimSpace(lin))
 		if err != nil {
-			log.Printf("error: %s", err)
+			if err != io.EOF {
+				log.Printf("error: %s", err)
+			}
 			return
 		}
+	}
+}
 
-		lin = strings.TrimSpace(lin)
+// stdErrLine handles a line cockroach wrote to stderr
+func (pi *testServer) stdErrLine(lin string) {
+	if len(lin) == 0 {
+		return
+	}
 
-		if len(lin) == 0 {
-			continue
-		}
+	pi.tailLk.Lock()
+	pi.tail = append(pi.tail, lin)
+	if len(pi.tail) > testServerTail {
+		pi.tail = pi.tail[len(pi.tail)-testServerTail:]
+	}
+	pi.tailLk.Unlock()
 
-		switch lin[0] {
-		case 'I', 'W':
-			// Info or Warn: do nothin
-		default:
-			log.Printf("[cockroach] %s", lin)
-		}
+	if pi.logSink != nil {
+		pi.logSink(lin)
+		return
+	}
+
+	switch lin[0] {
+	case 'I', 'W':
+		// Info or Warn: do nothin
+	default:
+		log.Printf("[cockroach] %s", lin)
+	}
+}
+
+// wait will execute cmd.Wait(), then close the output pipes and done once the process ended
+func (pi *testServer) wait(pipes ...io.Closer) {
+	err := pi.cmd.Wait()
+	for _, p := range pipes {
+		p.Close()
+	}
+	<-pi.outDone
+
+	os.RemoveAll(pi.dir)
+	pi.exitErr = err
+	close(pi.done)
+}
+
+// stop kills the server and waits for it to end
+func (pi *testServer) stop() error {
+	select {
+	case <-pi.done:
+		// already ended
+		return nil
+	default:
+	}
+
+	// data is in memory and will be lost anyway, no need for a graceful shutdown
+	if err := killTestProcess(pi.cmd.Process); err != nil {
+		return err
+	}
+
+	select {
+	case <-pi.done:
+		return nil
+	case <-time.After(10 * time.Second):
+		return errors.New("timeout waiting for cockroach to stop")
+	}
+}
+
+// terminate asks the server to shut down, and kills it if it is still running after timeout
+func (pi *testServer) terminate(timeout time.Duration) error {
+	if err := pi.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return pi.stop()
+	}
+
+	select {
+	case <-pi.done:
+		return nil
+	case <-time.After(timeout):
+		return pi.stop()
 	}
 }
 
-// wait will execute cmd.Wait() to ensure stderr is closed in case the process ends
-func (pi *testServer) wait() {
-	pi.cmd.Wait()
-	pi.ended = true
+// listeningURL returns the DSN written by cockroach to the listening url file
+func (pi *testServer) listeningURL() (string, error) {
+	dat, err := os.ReadFile(filepath.Join(pi.dir, "url"))
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(dat))
+	if v == "" {
+		// file created but not written yet
+		return "", errors.New("listening url file is empty")
+	}
+
+	u, err := url.Parse(v)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/defaultdb"
+	}
+	q := u.Query()
+	if q.Get("sslmode") == "" {
+		q.Set("sslmode", "disable")
+		u.RawQuery = q.Encode()
+	}
+	return u.String(), nil
 }
 
 // attemptConnect will attempt to connect to a given dsn, reporting any errors
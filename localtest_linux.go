@@ -0,0 +1,22 @@
+package froach
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setTestProcAttr runs the test server in its own process group, and makes sure it is killed if
+// the test binary dies without stopping it (panic, SIGKILL, etc). Pdeathsig is tied to the
+// thread starting the process, but the Go runtime only ends threads locked with LockOSThread.
+func setTestProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+}
+
+// killTestProcess kills the test server process group
+func killTestProcess(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
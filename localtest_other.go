@@ -0,0 +1,18 @@
+//go:build !linux
+
+package froach
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setTestProcAttr does nothing on this platform, StopLocalTestServer must be called to stop
+// the test server (see RunTests)
+func setTestProcAttr(cmd *exec.Cmd) {
+}
+
+// killTestProcess kills the test server process
+func killTestProcess(p *os.Process) error {
+	return p.Kill()
+}
@@ -10,6 +10,10 @@ import (
 	_ "github.com/jackc/pgx/v5"
 )
 
+func TestMain(m *testing.M) {
+	froach.RunTests(m)
+}
+
 func TestLocalTest(t *testing.T) {
 	// this tests if we actually run a server
 	dsn, err := froach.LocalTestServer()
@@ -34,3 +38,82 @@ func TestLocalTest(t *testing.T) {
 
 	log.Printf("version = %v", res)
 }
+
+func TestNewTestDB(t *testing.T) {
+	db := froach.NewTestDBWithOptions(t, froach.TestDBOptions{User: true})
+
+	pool, err := db.Pool()
+	if err != nil {
+		t.Fatalf("failed to create pool: %s", err)
+	}
+
+	var name string
+	if err := pool.QueryRow(context.Background(), "SELECT current_database()").Scan(&name); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if name != db.Name {
+		t.Errorf("expected database %s, got %s", db.Name, name)
+	}
+}
+
+func TestLocalTestCluster(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping cluster test in short mode")
+	}
+	c, err := froach.LocalTestCluster(3, froach.TestServerOptions{})
+	if err != nil {
+		t.Skipf("unable to launch cockroach cluster: %s", err)
+		return
+	}
+	defer c.Stop()
+
+	if err := c.KillNode(1); err != nil {
+		t.Fatalf("failed to kill node: %s", err)
+	}
+
+	conn, err := pgx.Connect(context.Background(), c.DSN(0))
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(context.Background(), "CREATE TABLE t (id INT PRIMARY KEY)"); err != nil {
+		t.Errorf("cluster should remain available with one node down: %s", err)
+	}
+
+	if err := c.RestartNode(1); err != nil {
+		t.Fatalf("failed to restart node: %s", err)
+	}
+}
+
+func TestTestDBSnapshot(t *testing.T) {
+	db := froach.NewTestDB(t)
+
+	pool, err := db.Pool()
+	if err != nil {
+		t.Fatalf("failed to create pool: %s", err)
+	}
+	ctx := context.Background()
+
+	if _, err := pool.Exec(ctx, "CREATE TABLE t (id INT PRIMARY KEY); INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot failed: %s", err)
+	}
+	if _, err := pool.Exec(ctx, "INSERT INTO t VALUES (2)"); err != nil {
+		t.Fatalf("insert failed: %s", err)
+	}
+	if err := db.Restore(snap); err != nil {
+		t.Fatalf("restore failed: %s", err)
+	}
+
+	var n int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM t").Scan(&n); err != nil {
+		t.Fatalf("query failed: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 row after restore, got %d", n)
+	}
+}
@@ -1,12 +1,8 @@
 package froach
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/x509"
-	"errors"
-	"io/fs"
+	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/KarpelesLab/fleet"
@@ -17,26 +13,29 @@ func init() {
 }
 
 func start() {
-	fleet.Self().DbWatch("froach:ca:key!", updateKey)
-	fleet.Self().WaitReady()    // this will wait for fleet to start
+	a := fleet.Self() // this will wait for the fleet agent to be created
+	clientCA.watchKey(updateClientKey)
+	nextCA.watchKey(updateNextKey)
+	a.WaitReady()               // this will wait for fleet to start
 	time.Sleep(5 * time.Second) // give a bit of time just in case
 
-	k, err := fleet.Self().DbGet("froach:ca:key!")
-	if errors.Is(err, fs.ErrNotExist) {
-		// no key? generate one
-		newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err == nil {
-			kData, err := x509.MarshalPKCS8PrivateKey(newKey)
-			if err == nil {
-				// let's try to use this key
-				// DbSet will trigger the watcher, that will call updateKey accordingly
-				fleet.Self().DbSet("froach:ca:key!", kData)
-			}
-		}
-	} else {
-		// initially set the key
-		updateKey("froach:ca:key!", k)
+	if err := registerHost(); err != nil {
+		slog.Error(fmt.Sprintf("[froach] failed to register host: %s", err), "event", "froach:host:register_fail")
 	}
 
+	// resume any CA rotation in progress, before the node CA key is set so certificates already
+	// issued by the new CA are kept
+	nextCA.syncKey(updateNextKey)
+
+	if err := getCABackend().Start(setCAKey); err != nil {
+		slog.Error(fmt.Sprintf("failed to obtain CA key: %s", err), "event", "froach:ca:backend_fail")
+	}
+
+	// client CA only exists if split PKI was enabled
+	clientCA.syncKey(updateClientKey)
+
+	if UseOCSP {
+		go serveOCSP()
+	}
 	go monitor()
 }
@@ -0,0 +1,182 @@
+package froach
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/KarpelesLab/goupd"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/ocsp"
+)
+
+// cockroach checks certificates against the OCSP responder listed in these. Each host runs its
+// own responder on localhost, answering from the revocations stored in fleet DB.
+const ocspAddr = "127.0.0.1:36259"
+
+var (
+	// UseOCSP enables certificate revocation checks through OCSP: each host runs a responder on
+	// localhost, certificates list it and cockroach is configured to query it. It must be set
+	// before fleet starts, such as in main(). Enabling it on an existing fleet reissues all node
+	// and client certificates once, on every host.
+	UseOCSP = false
+
+	ocspURL = "http://" + ocspAddr + "/"
+
+	// ocspEnabled is set once security.ocsp.mode was set, only accessed from monitor()
+	ocspEnabled bool
+)
+
+// serveOCSP runs the local OCSP responder
+func serveOCSP() {
+	err := http.ListenAndServe(ocspAddr, http.HandlerFunc(handleOCSP))
+	slog.Error(fmt.Sprintf("[froach] OCSP responder failed: %s", err), "event", "froach:ocsp:fail")
+}
+
+func handleOCSP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodPost:
+		der, err = io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	case http.MethodGet:
+		// RFC 6960 A.1: base64 encoded request in the path
+		var p string
+		p, err = url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+		if err == nil {
+			der, err = base64.StdEncoding.DecodeString(p)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(ocspRespond(der, time.Now()))
+}
+
+// ocspRespond returns the response to a DER encoded OCSP request
+func ocspRespond(der []byte, now time.Time) []byte {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse
+	}
+
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	var a *authority
+	for _, v := range append(authorities(), nextCA) {
+		if v.ready() && bytes.Equal(issuerKeyHash(v.crt, req.HashAlgorithm), req.IssuerKeyHash) {
+			a = v
+			break
+		}
+	}
+	if a == nil {
+		return ocsp.UnauthorizedErrorResponse
+	}
+
+	tpl := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Hour),
+	}
+	if t, ok := revoked[serialHex(req.SerialNumber)]; ok {
+		tpl.Status = ocsp.Revoked
+		tpl.RevokedAt = t
+	} else if !issuedSerial(req.SerialNumber) {
+		// not issued by froach, or not published yet by the host it was issued to
+		tpl.Status = ocsp.Unknown
+	}
+
+	// all hosts share the CA key, so responses can be signed directly with the local CA
+	res, err := ocsp.CreateResponse(a.crt, a.crt, tpl, a.key)
+	if err != nil {
+		slog.Error(fmt.Sprintf("[froach] failed to create OCSP response: %s", err), "event", "froach:ocsp:sign_fail")
+		return ocsp.InternalErrorErrorResponse
+	}
+	return res
+}
+
+// issuedSerial returns true if a certificate with the given serial is found in the local
+// certificates or in the summaries published by other hosts. keyLk must be held.
+func issuedSerial(serial *big.Int) bool {
+	hex := serialHex(serial)
+	has := func(l []*CertificateInfo) bool {
+		return slices.ContainsFunc(l, func(c *CertificateInfo) bool { return c.Serial == hex })
+	}
+
+	if l, err := certificates(); err == nil && has(l) {
+		return true
+	}
+	hosts, err := FleetCertificates()
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[froach] failed to load fleet certificates: %s", err), "event", "froach:ocsp:inventory_fail")
+		return false
+	}
+	for _, h := range hosts {
+		if has(h.Certificates) {
+			return true
+		}
+	}
+	return false
+}
+
+// issuerKeyHash returns the hash of the CA public key as used in OCSP requests
+func issuerKeyHash(crt *x509.Certificate, h crypto.Hash) []byte {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(crt.RawSubjectPublicKeyInfo, &spki); err != nil || !h.Available() {
+		return nil
+	}
+	hh := h.New()
+	hh.Write(spki.PublicKey.RightAlign())
+	return hh.Sum(nil)
+}
+
+// enableOCSP tells cockroach to check certificates against the OCSP responder. lax mode is
+// used so connections still work if the responder is unavailable.
+func enableOCSP() {
+	if !UseOCSP || ocspEnabled || goupd.MODE == "DEV" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := pgconn.Connect(ctx, rootDSN())
+	if err != nil {
+		// cockroach may still be starting, or the cluster not initialized yet
+		slog.Debug(fmt.Sprintf("[froach] failed to connect to enable OCSP: %s", err), "event", "froach:ocsp:connect_fail")
+		return
+	}
+	defer c.Close(context.Background())
+
+	if _, err := c.Exec(ctx, "SET CLUSTER SETTING security.ocsp.mode = 'lax'").ReadAll(); err != nil {
+		slog.Debug(fmt.Sprintf("[froach] failed to enable OCSP: %s", err), "event", "froach:ocsp:enable_fail")
+		return
+	}
+	ocspEnabled = true
+	slog.Info("[froach] enabled OCSP certificate checks in cockroach", "event", "froach:ocsp:enabled")
+}
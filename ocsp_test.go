@@ -0,0 +1,112 @@
+package froach
+
+import (
+	"crypto"
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPRespond(t *testing.T) {
+	caKey, ca := testCA(t)
+	_, leaf := testLeaf(t, caKey, ca, "alice")
+	_, otherCA := testCA(t)
+
+	s := testFleet(t)
+	keyLk.Lock()
+	oldRevoked := revoked
+	nodeCA.key, nodeCA.crt, revoked = caKey, ca, make(map[string]time.Time)
+	keyLk.Unlock()
+	t.Cleanup(func() {
+		keyLk.Lock()
+		revoked = oldRevoked
+		keyLk.Unlock()
+	})
+
+	// the certificate was issued to another host
+	dat, _ := json.Marshal(&HostCertificates{Id: "other", Certificates: []*CertificateInfo{{File: "client.alice.crt", Serial: serialHex(leaf.SerialNumber)}}})
+	s.DbSet("froach:certs:other", dat)
+
+	req, err := ocsp.CreateRequest(leaf, ca, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+
+	res, err := ocsp.ParseResponseForCert(ocspRespond(req, time.Now()), leaf, ca)
+	if err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+	if res.Status != ocsp.Good {
+		t.Errorf("expected good status, got %d", res.Status)
+	}
+
+	// not issued by froach
+	_, unknown := testLeaf(t, caKey, ca, "mallory")
+	unknown.SerialNumber = big.NewInt(3)
+	req2, err := ocsp.CreateRequest(unknown, ca, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	res, err = ocsp.ParseResponse(ocspRespond(req2, time.Now()), ca)
+	if err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+	if res.Status != ocsp.Unknown {
+		t.Errorf("expected unknown status, got %d", res.Status)
+	}
+
+	keyLk.Lock()
+	revoked[serialHex(leaf.SerialNumber)] = time.Now().Add(-time.Minute)
+	keyLk.Unlock()
+
+	res, err = ocsp.ParseResponseForCert(ocspRespond(req, time.Now()), leaf, ca)
+	if err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+	if res.Status != ocsp.Revoked {
+		t.Errorf("expected revoked status, got %d", res.Status)
+	}
+
+	// unknown issuer
+	req, err = ocsp.CreateRequest(leaf, otherCA, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	if _, err := ocsp.ParseResponse(ocspRespond(req, time.Now()), nil); err == nil {
+		t.Errorf("expected error response for unknown issuer")
+	}
+}
+
+func TestUseOCSP(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+	t.Cleanup(func() { UseOCSP = false })
+
+	crt, err := readCertificateFile(filepath.Join(basePath(), "node.crt"))
+	if err != nil {
+		t.Fatalf("failed to read node.crt: %s", err)
+	}
+	if len(crt.OCSPServer) != 0 {
+		t.Errorf("certificate lists an OCSP responder without UseOCSP")
+	}
+
+	UseOCSP = true
+	keyLk.Lock()
+	updated, err := checkNodeKeys()
+	keyLk.Unlock()
+	if err != nil || !updated {
+		t.Fatalf("expected certificates to be reissued with UseOCSP (%v)", err)
+	}
+	crt, err = readCertificateFile(filepath.Join(basePath(), "node.crt"))
+	if err != nil {
+		t.Fatalf("failed to read node.crt: %s", err)
+	}
+	if !slices.Equal(crt.OCSPServer, []string{ocspURL}) {
+		t.Errorf("unexpected OCSP responders %v", crt.OCSPServer)
+	}
+}
@@ -6,16 +6,25 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"slices"
 )
 
-// readPrivateKeyFile returns a private key from a given PEM file
+// readPrivateKeyFile returns a private key from a given PEM file. PKCS#1 and SEC 1 keys (as
+// written by "cockroach cert" or openssl) are accepted too.
 func readPrivateKeyFile(fn string) (crypto.PrivateKey, error) {
-	b, err := readPemFile(fn, "PRIVATE KEY")
+	b, err := readPemFile(fn, "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY")
 	if err != nil {
 		return nil, err
 	}
 
-	return x509.ParsePKCS8PrivateKey(b.Bytes)
+	switch b.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(b.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(b.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(b.Bytes)
+	}
 }
 
 // readCertificateFile returns a certificate from a given PEM file
@@ -28,8 +37,8 @@ func readCertificateFile(fn string) (*x509.Certificate, error) {
 	return x509.ParseCertificate(b.Bytes)
 }
 
-// readPemFile reads a PEM file and returns the first block found of the given type
-func readPemFile(fn, typ string) (*pem.Block, error) {
+// readPemFile reads a PEM file and returns the first block found of any of the given types
+func readPemFile(fn string, types ...string) (*pem.Block, error) {
 	dat, err := os.ReadFile(fn)
 	if err != nil {
 		return nil, err
@@ -41,10 +50,10 @@ func readPemFile(fn, typ string) (*pem.Block, error) {
 		if b == nil {
 			break
 		}
-		if b.Type == typ {
+		if slices.Contains(types, b.Type) {
 			return b, nil
 		}
 	}
 
-	return nil, fmt.Errorf("failed to parse PEM file %s: %s not found", typ, fn)
+	return nil, fmt.Errorf("failed to parse PEM file %s: %s not found", fn, types[0])
 }
@@ -0,0 +1,60 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPrivateKeyFile(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	sec1, _ := x509.MarshalECPrivateKey(ecKey)
+
+	tests := []struct {
+		name string
+		typ  string
+		der  []byte
+		pub  crypto.PublicKey
+	}{
+		{"pkcs8", "PRIVATE KEY", pkcs8, ecKey.Public()},
+		{"sec1", "EC PRIVATE KEY", sec1, ecKey.Public()},
+		{"pkcs1", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), rsaKey.Public()},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		fn := filepath.Join(dir, tt.name+".key")
+		if err := os.WriteFile(fn, pem.EncodeToMemory(&pem.Block{Type: tt.typ, Bytes: tt.der}), 0600); err != nil {
+			t.Fatalf("failed to write key: %s", err)
+		}
+		k, err := readPrivateKeyFile(fn)
+		if err != nil {
+			t.Errorf("%s: failed to read key: %s", tt.name, err)
+			continue
+		}
+		signer, ok := k.(crypto.Signer)
+		if !ok {
+			t.Errorf("%s: key of type %T is not a signer", tt.name, k)
+			continue
+		}
+		if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.pub) {
+			t.Errorf("%s: public key mismatch", tt.name)
+		}
+	}
+}
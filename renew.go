@@ -0,0 +1,106 @@
+package froach
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/KarpelesLab/runutil"
+)
+
+var (
+	// CertRenewBefore is the duration before expiration at which certificates (including the CA) are re-issued
+	CertRenewBefore = 30 * 24 * time.Hour
+
+	// CertWarnBefore is the duration before expiration at which warnings start being logged
+	CertWarnBefore = 45 * 24 * time.Hour
+
+	// lastWarn tracks when we last warned about a given file, protected by keyLk
+	lastWarn = make(map[string]time.Time)
+)
+
+// expiring returns true if the certificate should be renewed now, and logs a warning (at most
+// once a day per file) if expiration is approaching
+//
+// Short lived certificates are renewed once two thirds of their lifetime has passed if that
+// happens before CertRenewBefore.
+func expiring(name string, crt *x509.Certificate) bool {
+	left := time.Until(crt.NotAfter)
+	renew, warn := CertRenewBefore, CertWarnBefore
+	if lifetime := crt.NotAfter.Sub(crt.NotBefore); renew > lifetime/3 {
+		renew, warn = lifetime/3, lifetime/2
+	}
+
+	if left < warn && time.Since(lastWarn[name]) > 24*time.Hour {
+		lastWarn[name] = time.Now()
+		slog.Warn(fmt.Sprintf("[froach] certificate %s expires in %s (%s)", name, left.Round(time.Second), crt.NotAfter), "event", "froach:cert:expiring", "file", name)
+	}
+
+	return left < renew
+}
+
+// certCheck re-issues the CA and certificates approaching expiration and tells cockroach to
+// reload them
+func certCheck() {
+	defer func() {
+		if e := recover(); e != nil {
+			slog.Error(fmt.Sprintf("certificate check panic: %s", e), "event", "froach:cert_check:panic")
+		}
+	}()
+
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	if !nodeCA.ready() {
+		// no key yet
+		return
+	}
+
+	updated := false
+	for _, a := range authorities() {
+		if !a.ready() || !expiring(a.crtFile, a.crt) {
+			continue
+		}
+		slog.Info(fmt.Sprintf("[froach] renewing %s expiring on %s", a.crtFile, a.crt.NotAfter), "event", "froach:ca:renew")
+		if err := a.createCA(a.key); err != nil {
+			slog.Error(fmt.Sprintf("[froach] failed to renew %s: %s", a.crtFile, err), "event", "froach:ca:renew_fail")
+			return
+		}
+		updated = true
+	}
+
+	crlCheck()
+
+	upd, err := checkNodeKeys()
+	if err != nil {
+		slog.Error(fmt.Sprintf("[froach] failed to renew certificates: %s", err), "event", "froach:cert:renew_fail")
+	}
+
+	if _, err := checkClientCerts(); err != nil {
+		slog.Error(fmt.Sprintf("[froach] failed to renew client certificates: %s", err), "event", "froach:cert:client_renew_fail")
+	}
+
+	if updated || upd {
+		reloadCockroach()
+	}
+
+	publishCertificates()
+}
+
+// reloadCockroach sends SIGHUP to running cockroach processes so these reload their certificates
+func reloadCockroach() {
+	for _, pid := range runutil.PidOf("cockroach") {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			continue
+		}
+		if err := p.Signal(syscall.SIGHUP); err != nil {
+			slog.Warn(fmt.Sprintf("[froach] failed to signal cockroach pid %d: %s", pid, err), "event", "froach:reload:fail")
+			continue
+		}
+		slog.Info(fmt.Sprintf("[froach] asked cockroach pid %d to reload certificates", pid), "event", "froach:reload")
+	}
+}
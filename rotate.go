@@ -0,0 +1,348 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CA rotation happens in the following steps, each host confirming each step in fleet DB under
+// froach:ca:rotate:<host id>:
+//
+//  1. RotateCA() publishes a new key as the key of nextCA
+//  2. each host adds the new CA to ca.crt (which then contains both CAs) and confirms "trust"
+//  3. once all hosts trust the new CA, each host reissues its certificates with the new CA and
+//     confirms "reissued"
+//  4. once all hosts have reissued their certificates, the new key replaces the key of nodeCA,
+//     the key of nextCA is removed and the old CA is dropped from ca.crt
+//
+// All hosts registered under froach:host:<host id> take part, including hosts that are currently
+// offline. Hosts that were removed from the fleet must be forgotten with ForgetHost.
+const (
+	rotateStageTrust    = "trust"
+	rotateStageReissued = "reissued"
+)
+
+// RotationStatus describes the progress of a CA rotation
+type RotationStatus struct {
+	NewCA   string            `json:"new_ca"`  // hash of the new CA public key
+	Stage   string            `json:"stage"`   // stage reached by this host
+	Peers   map[string]string `json:"peers"`   // stage confirmed by each host, by host id
+	Started time.Time         `json:"started"` // when this host learned about the rotation
+}
+
+var (
+	// nextCA holds the CA being rotated in, if any
+	nextCA = &authority{dbKey: "froach:ca:next", genKey: "froach:ca:next:gen", subjKey: nodeCA.subjKey, crtFile: "ca.crt", name: "CockroachDB CA"}
+
+	rotateStage   string // protected by keyLk
+	rotateStarted time.Time
+)
+
+var (
+	// ErrRotationInProgress is returned by RotateCA if a rotation is already happening
+	ErrRotationInProgress = errors.New("CA rotation already in progress")
+
+	// ErrRotationUnsupported is returned by RotateCA if the CA key does not come from fleet DB
+	ErrRotationUnsupported = errors.New("CA rotation is only supported with FleetDBBackend")
+)
+
+// RotateCA starts the rotation of the node CA key across the fleet. Progress can be followed
+// through GetStatus().Rotation.
+func RotateCA() error {
+	if _, ok := getCABackend().(*fleetDBBackend); !ok {
+		return ErrRotationUnsupported
+	}
+
+	ref, err := nextCA.keyRef()
+	if err != nil {
+		return err
+	}
+	if ref != "" {
+		return ErrRotationInProgress
+	}
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("[froach] starting CA rotation", "event", "froach:rotate:start")
+
+	// this will trigger the watcher, that will call updateNextKey accordingly
+	return nextCA.storeKey(newKey)
+}
+
+// updateNextKey is called when the key of nextCA changes
+func updateNextKey(key crypto.Signer) {
+	if key == nil {
+		endRotation()
+		return
+	}
+
+	if err := startRotation(key); err != nil {
+		slog.Error(fmt.Sprintf("[froach] CA rotation failed: %s", err), "event", "froach:rotate:fail")
+	}
+}
+
+// startRotation adds the new CA to the list of trusted CAs (step 2)
+func startRotation(key crypto.Signer) error {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	if nextCA.key != nil && sameKey(nextCA.key, key) {
+		// already know about it
+		return nil
+	}
+	if nodeCA.key != nil && sameKey(nodeCA.key, key) {
+		// rotation already completed here
+		return nil
+	}
+
+	crt, err := nextCA.newCACert(key)
+	if err != nil {
+		return err
+	}
+	nextCA.key = key
+	nextCA.crt = crt
+	rotateStarted = time.Now()
+
+	// resume from the stage confirmed before froach restarted, if any
+	stage := rotateStageTrust
+	if peerStage(store().Id()) == rotateStageReissued {
+		stage = rotateStageReissued
+	}
+
+	if nodeCA.ready() {
+		nodeCA.trust = []*x509.Certificate{crt}
+		if err := nodeCA.writeCrt(); err != nil {
+			return err
+		}
+		reloadCockroach()
+	}
+
+	slog.Info("[froach] CA rotation: now trusting new CA", "event", "froach:rotate:trust")
+	return setRotateStage(stage)
+}
+
+// endRotation is called when the key of nextCA is removed, either because the rotation completed
+// or because it was aborted
+func endRotation() {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	if nextCA.key == nil {
+		return
+	}
+
+	if nodeCA.ready() && !sameKey(nodeCA.key, nextCA.key) {
+		if k, _, err := nodeCA.loadKey(); err == nil && k != nil && sameKey(k, nextCA.key) {
+			// promoted but not loaded yet, rotationKeySet will complete the rotation
+			return
+		}
+		// rotation aborted before completion, stop trusting the new CA
+		slog.Warn("[froach] CA rotation aborted", "event", "froach:rotate:abort")
+		nodeCA.trust = nil
+		if err := nodeCA.writeCrt(); err != nil {
+			slog.Error(fmt.Sprintf("[froach] failed to write CA: %s", err), "event", "froach:rotate:fail")
+		}
+		reloadCockroach()
+	}
+
+	nextCA.key = nil
+	nextCA.crt = nil
+	setRotateStage("")
+}
+
+// rotationKeySet is called when the node CA key is set, and returns the CA certificates that
+// need to be trusted on top of the new CA. keyLk must be held.
+func rotationKeySet(key crypto.Signer) []*x509.Certificate {
+	if nextCA.key == nil {
+		return nil
+	}
+	if sameKey(nextCA.key, key) {
+		// rotation complete, the old CA doesn't need to be trusted anymore
+		slog.Info("[froach] CA rotation completed", "event", "froach:rotate:done")
+		nextCA.key = nil
+		nextCA.crt = nil
+		setRotateStage("")
+		return nil
+	}
+	// still rotating (froach restarted during rotation)
+	return []*x509.Certificate{nextCA.crt}
+}
+
+// rotationCheck moves the rotation to the next step once all peers have confirmed the
+// current one
+func rotationCheck() {
+	defer func() {
+		if e := recover(); e != nil {
+			slog.Error(fmt.Sprintf("rotation check panic: %s", e), "event", "froach:rotate_check:panic")
+		}
+	}()
+
+	var promote crypto.Signer
+
+	func() {
+		keyLk.Lock()
+		defer keyLk.Unlock()
+
+		if nextCA.key == nil || !nodeCA.ready() {
+			return
+		}
+		updateRotationStatus()
+
+		switch rotateStage {
+		case rotateStageTrust:
+			if !peersReached(rotateStageTrust) {
+				return
+			}
+			// step 3: reissue certificates with the new CA, still trusting the old one
+			slog.Info("[froach] CA rotation: reissuing certificates with new CA", "event", "froach:rotate:reissue")
+			oldCrt := nodeCA.crt
+			nodeCA.key, nodeCA.crt = nextCA.key, nextCA.crt
+			nodeCA.trust = []*x509.Certificate{oldCrt}
+			if err := nodeCA.writeCrt(); err != nil {
+				slog.Error(fmt.Sprintf("[froach] failed to write CA: %s", err), "event", "froach:rotate:fail")
+				return
+			}
+			if _, err := checkNodeKeys(); err != nil {
+				slog.Error(fmt.Sprintf("[froach] failed to reissue certificates: %s", err), "event", "froach:rotate:fail")
+				return
+			}
+			if _, err := checkClientCerts(); err != nil {
+				slog.Error(fmt.Sprintf("[froach] failed to reissue client certificates: %s", err), "event", "froach:rotate:fail")
+			}
+			reloadCockroach()
+			setRotateStage(rotateStageReissued)
+		case rotateStageReissued:
+			if !peersReached(rotateStageReissued) {
+				return
+			}
+			// step 4: everyone uses the new CA, make it the main key
+			promote = nextCA.key
+		}
+	}()
+
+	if promote != nil {
+		// storing keys triggers watchers which need keyLk
+		slog.Info("[froach] CA rotation: all hosts confirmed, promoting new CA", "event", "froach:rotate:promote")
+		if err := nodeCA.storeKey(promote); err != nil {
+			slog.Error(fmt.Sprintf("[froach] failed to promote new CA: %s", err), "event", "froach:rotate:fail")
+			return
+		}
+		if err := nextCA.removeKey(); err != nil {
+			slog.Error(fmt.Sprintf("[froach] failed to remove next CA: %s", err), "event", "froach:rotate:fail")
+		}
+	}
+}
+
+// registerHost records this host in fleet DB, so rotations wait for it even while it is offline
+func registerHost() error {
+	return store().DbSet("froach:host:"+store().Id(), []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+// ForgetHost removes a host that left the fleet, so CA rotations don't wait for it anymore
+func ForgetHost(id string) error {
+	if err := store().DbDelete("froach:host:" + id); err != nil {
+		return err
+	}
+	return store().DbDelete("froach:ca:rotate:" + id)
+}
+
+// rotationHosts returns the ids of the hosts taking part in the rotation, which are all the
+// registered hosts whether these are connected or not
+func rotationHosts() []string {
+	self := store().Id()
+	hosts, err := store().DbList("froach:host:")
+	if err != nil {
+		slog.Warn(fmt.Sprintf("[froach] failed to list hosts: %s", err), "event", "froach:rotate:hosts_fail")
+	}
+
+	res := []string{self}
+	for id := range hosts {
+		if id != self {
+			res = append(res, id)
+		}
+	}
+	sort.Strings(res[1:])
+	return res
+}
+
+// peerStage returns the rotation stage confirmed by a given host for the current rotation.
+// keyLk must be held.
+func peerStage(id string) string {
+	v, err := store().DbGet("froach:ca:rotate:" + id)
+	if err != nil {
+		return ""
+	}
+	stage, hash, _ := strings.Cut(string(v), ":")
+	if h, err := pubKeyHash(nextCA.key.Public()); err != nil || h != hash {
+		// confirmation for another rotation
+		return ""
+	}
+	return stage
+}
+
+// peersReached returns true if all hosts have reached at least the given stage. keyLk must be held.
+func peersReached(stage string) bool {
+	for _, id := range rotationHosts() {
+		switch peerStage(id) {
+		case rotateStageReissued:
+			// reached all stages
+		case stage:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setRotateStage records the stage reached by this host. keyLk must be held.
+func setRotateStage(stage string) error {
+	rotateStage = stage
+	defer updateRotationStatus()
+
+	k := "froach:ca:rotate:" + store().Id()
+	if stage == "" {
+		return store().DbDelete(k)
+	}
+	hash, err := pubKeyHash(nextCA.key.Public())
+	if err != nil {
+		return err
+	}
+	return store().DbSet(k, []byte(stage+":"+hash))
+}
+
+// updateRotationStatus refreshes the rotation information in Status. keyLk must be held.
+func updateRotationStatus() {
+	if nextCA.key == nil {
+		updateStatus(func(s *Status) { s.Rotation = nil })
+		return
+	}
+
+	st := &RotationStatus{
+		Stage:   rotateStage,
+		Peers:   make(map[string]string),
+		Started: rotateStarted,
+	}
+	st.NewCA, _ = pubKeyHash(nextCA.key.Public())
+	for _, id := range rotationHosts() {
+		st.Peers[id] = peerStage(id)
+	}
+	updateStatus(func(s *Status) { s.Rotation = st })
+}
+
+// sameKey returns true if both keys have the same public key
+func sameKey(a, b crypto.Signer) bool {
+	pub, ok := a.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b.Public())
+}
@@ -0,0 +1,144 @@
+package froach
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateCA(t *testing.T) {
+	s := testFleet(t)
+	testStartFleetDB(t)
+	nextCA.watchKey(updateNextKey)
+	if err := registerHost(); err != nil {
+		t.Fatalf("failed to register host: %s", err)
+	}
+	// another host, currently offline
+	s.DbSet("froach:host:other", []byte("registered"))
+
+	oldKey := authorityKey(nodeCA)
+	if err := RotateCA(); err != nil {
+		t.Fatalf("failed to start rotation: %s", err)
+	}
+	if err := RotateCA(); err != ErrRotationInProgress {
+		t.Fatalf("expected rotation in progress, got %v", err)
+	}
+	newKey := authorityKey(nextCA)
+	if newKey == nil {
+		t.Fatalf("next CA not loaded")
+	}
+	rot := GetStatus().Rotation
+	if rot == nil || rot.Stage != rotateStageTrust {
+		t.Fatalf("unexpected rotation status %+v", rot)
+	}
+
+	// offline host hasn't confirmed anything yet
+	rotationCheck()
+	if rotateStage != rotateStageTrust {
+		t.Fatalf("rotation moved on without the offline host")
+	}
+
+	s.DbSet("froach:ca:rotate:other", []byte(rotateStageTrust+":"+rot.NewCA))
+	rotationCheck()
+	if rotateStage != rotateStageReissued {
+		t.Fatalf("expected stage %s, got %s", rotateStageReissued, rotateStage)
+	}
+	crt, err := readCertificateFile(filepath.Join(basePath(), "node.crt"))
+	if err != nil {
+		t.Fatalf("failed to read node.crt: %s", err)
+	}
+	keyLk.Lock()
+	err = crt.CheckSignatureFrom(nextCA.crt)
+	keyLk.Unlock()
+	if err != nil {
+		t.Errorf("node.crt not reissued with the new CA: %s", err)
+	}
+
+	rotationCheck()
+	if authorityKey(nextCA) == nil {
+		t.Fatalf("new CA promoted before the offline host reissued its certificates")
+	}
+
+	s.DbSet("froach:ca:rotate:other", []byte(rotateStageReissued+":"+rot.NewCA))
+	rotationCheck()
+
+	if !sameKey(authorityKey(nodeCA), newKey) {
+		t.Fatalf("node CA key was not replaced")
+	}
+	if authorityKey(nextCA) != nil || GetStatus().Rotation != nil {
+		t.Errorf("rotation still in progress after promotion")
+	}
+	keyLk.Lock()
+	l, err := readCertificateInfo(basePath(), "ca.crt")
+	keyLk.Unlock()
+	if err != nil || len(l) != 1 {
+		t.Errorf("expected ca.crt to only contain the new CA, got %d certificates (%v)", len(l), err)
+	}
+
+	// hosts restarting load the new key
+	stored, _, err := nodeCA.loadKey()
+	if err != nil || !sameKey(stored, newKey) || sameKey(stored, oldKey) {
+		t.Errorf("fleet DB does not hold the new key (%v)", err)
+	}
+	if ref, err := nextCA.keyRef(); err != nil || ref != "" {
+		t.Errorf("next CA still stored in fleet DB: %q (%v)", ref, err)
+	}
+
+	// retired key material is deleted, except the first key which fleet DB can't delete
+	if l, _ := s.DbList("froach:ca:key:"); len(l) != 1 {
+		t.Errorf("expected a single node CA key generation, got %d", len(l))
+	}
+	if l, _ := s.DbList("froach:ca:next:"); len(l) != 1 || l["gen"] == nil {
+		t.Errorf("expected next CA keys to be deleted, got %d keys", len(l))
+	}
+
+	// a new rotation can start
+	if err := RotateCA(); err != nil {
+		t.Errorf("failed to start another rotation: %s", err)
+	}
+}
+
+func TestRotateCARestart(t *testing.T) {
+	s := testFleet(t)
+	testStartFleetDB(t)
+	nextCA.watchKey(updateNextKey)
+	if err := registerHost(); err != nil {
+		t.Fatalf("failed to register host: %s", err)
+	}
+	s.DbSet("froach:host:other", []byte("registered"))
+
+	if err := RotateCA(); err != nil {
+		t.Fatalf("failed to start rotation: %s", err)
+	}
+	hash := GetStatus().Rotation.NewCA
+	s.DbSet("froach:ca:rotate:other", []byte(rotateStageTrust+":"+hash))
+	rotationCheck()
+	if rotateStage != rotateStageReissued {
+		t.Fatalf("expected stage %s, got %s", rotateStageReissued, rotateStage)
+	}
+	reissued, err := os.ReadFile(filepath.Join(basePath(), "node.crt"))
+	if err != nil {
+		t.Fatalf("failed to read node.crt: %s", err)
+	}
+
+	// restart froach, in the same order as start()
+	keyLk.Lock()
+	for _, a := range []*authority{nodeCA, clientCA, nextCA} {
+		a.key, a.crt, a.trust, a.ref = nil, nil, nil, ""
+	}
+	rotateStage = ""
+	keyLk.Unlock()
+	nextCA.syncKey(updateNextKey)
+	testStartFleetDB(t)
+
+	if rotateStage != rotateStageReissued {
+		t.Errorf("rotation went back to stage %s after restart", rotateStage)
+	}
+	crt, err := os.ReadFile(filepath.Join(basePath(), "node.crt"))
+	if err != nil {
+		t.Fatalf("failed to read node.crt: %s", err)
+	}
+	if string(crt) != string(reissued) {
+		t.Errorf("node.crt reissued with the old CA after restart")
+	}
+}
@@ -0,0 +1,120 @@
+package froach
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// TestSharedEnv is the environment variable that can be set to a non-empty value to enable
+// TestServerOptions.Shared, for example with FROACH_TEST_SHARED=1 go test ./... This requires
+// calling RunTests from TestMain, otherwise a dedicated server is started.
+const TestSharedEnv = "FROACH_TEST_SHARED"
+
+// runningTests is set by RunTests, which is required for shared test servers since it is where
+// the supervisor process runs
+var runningTests bool
+
+// SharedTestServerIdle is how long a shared test server keeps running once its last user left
+var SharedTestServerIdle = 30 * time.Second
+
+// sharedConfig is the configuration of a shared test server. Test binaries with the same
+// configuration share the same server.
+type sharedConfig struct {
+	Version         string            `json:"version,omitempty"`
+	StoreSize       string            `json:"store_size,omitempty"`
+	OnDisk          bool              `json:"on_disk,omitempty"`
+	ExtraFlags      []string          `json:"extra_flags,omitempty"`
+	ClusterSettings map[string]string `json:"cluster_settings,omitempty"` // applied once by the supervisor
+	Idle            time.Duration     `json:"idle"`
+}
+
+// sharedState is stored in the state file of a shared test server
+type sharedState struct {
+	Pid   int       `json:"pid"`             // pid of the supervisor process
+	DSN   string    `json:"dsn,omitempty"`   // set once the server is ready
+	Error string    `json:"error,omitempty"` // set if the server failed to start
+	Users []int     `json:"users"`           // pids of the test binaries using the server
+	Idle  time.Time `json:"idle,omitempty"`  // when the last user left
+}
+
+func newSharedConfig(opts TestServerOptions) *sharedConfig {
+	return &sharedConfig{
+		Version:         opts.Version,
+		StoreSize:       opts.StoreSize,
+		OnDisk:          opts.OnDisk,
+		ExtraFlags:      opts.ExtraFlags,
+		ClusterSettings: opts.ClusterSettings,
+		Idle:            SharedTestServerIdle,
+	}
+}
+
+// options returns the TestServerOptions used by the supervisor to start the server
+func (c *sharedConfig) options() TestServerOptions {
+	return TestServerOptions{
+		Version:         c.Version,
+		StoreSize:       c.StoreSize,
+		OnDisk:          c.OnDisk,
+		ExtraFlags:      c.ExtraFlags,
+		ClusterSettings: c.ClusterSettings,
+	}
+}
+
+// stateFile returns the path to the state file for this configuration. Its lock file has the
+// same name with a .lock suffix.
+func (c *sharedConfig) stateFile() string {
+	c2 := *c
+	c2.Idle = 0 // doesn't change the server itself
+	dat, _ := json.Marshal(&c2)
+	h := sha256.Sum256(dat)
+	return filepath.Join(cachePath(), "test-server-"+hex.EncodeToString(h[:8])+".json")
+}
+
+// readSharedState reads the state file, returning nil if it doesn't exist
+func readSharedState(fn string) (*sharedState, error) {
+	dat, err := os.ReadFile(fn)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st := &sharedState{}
+	if err := json.Unmarshal(dat, st); err != nil {
+		// corrupted, start over
+		return nil, nil
+	}
+	return st, nil
+}
+
+// writeSharedState writes the state file, or removes it if st is nil
+func writeSharedState(fn string, st *sharedState) error {
+	if st == nil {
+		err := os.Remove(fn)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	dat, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(fn+"~", dat, 0600); err != nil {
+		return err
+	}
+	return os.Rename(fn+"~", fn)
+}
+
+// pruneUsers removes the users for which alive returns false and returns true if any was removed
+func (st *sharedState) pruneUsers(alive func(pid int) bool) bool {
+	n := len(st.Users)
+	st.Users = slices.DeleteFunc(st.Users, func(pid int) bool { return !alive(pid) })
+	return len(st.Users) != n
+}
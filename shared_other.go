@@ -0,0 +1,13 @@
+//go:build !unix
+
+package froach
+
+import "errors"
+
+// superviseIfRequested does nothing since shared test servers aren't supported on this platform
+func superviseIfRequested() {}
+
+// attachSharedTestServer is not supported on this platform
+func attachSharedTestServer(opts TestServerOptions) (string, func() error, error) {
+	return "", nil, errors.New("shared test server is not supported on this platform")
+}
@@ -0,0 +1,53 @@
+package froach
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSharedState(t *testing.T) {
+	a := &sharedConfig{Version: "v23.2.5", Idle: time.Second}
+	b := &sharedConfig{Version: "v23.2.5", Idle: time.Minute}
+	c := &sharedConfig{Version: "v24.1.0", Idle: time.Second}
+	if a.stateFile() != b.stateFile() {
+		t.Errorf("idle timeout should not change the state file")
+	}
+	if a.stateFile() == c.stateFile() {
+		t.Errorf("different versions should use different state files")
+	}
+	d := &sharedConfig{Version: "v23.2.5", ClusterSettings: map[string]string{"a": "1", "b": "2"}}
+	e := &sharedConfig{Version: "v23.2.5", ClusterSettings: map[string]string{"b": "2", "a": "1"}}
+	if a.stateFile() == d.stateFile() {
+		t.Errorf("different cluster settings should use different state files")
+	}
+	if d.stateFile() != e.stateFile() {
+		t.Errorf("same cluster settings should use the same state file")
+	}
+
+	fn := filepath.Join(t.TempDir(), "state.json")
+	if st, err := readSharedState(fn); st != nil || err != nil {
+		t.Fatalf("expected no state, got %v, %v", st, err)
+	}
+
+	st := &sharedState{Pid: 1, Users: []int{10, 11, 12}}
+	st.pruneUsers(func(pid int) bool { return pid != 11 })
+	if err := writeSharedState(fn, st); err != nil {
+		t.Fatalf("failed to write state: %s", err)
+	}
+	st2, err := readSharedState(fn)
+	if err != nil || st2 == nil {
+		t.Fatalf("failed to read state: %v", err)
+	}
+	if !slices.Equal(st2.Users, []int{10, 12}) {
+		t.Errorf("unexpected users %v", st2.Users)
+	}
+
+	if err := writeSharedState(fn, nil); err != nil {
+		t.Errorf("failed to remove state: %s", err)
+	}
+	if st, _ := readSharedState(fn); st != nil {
+		t.Errorf("state should have been removed")
+	}
+}
@@ -0,0 +1,253 @@
+//go:build unix
+
+package froach
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sharedSupervisorEnv is set when re-executing the test binary as the supervisor of a shared
+// test server, and holds its sharedConfig
+const sharedSupervisorEnv = "FROACH_TEST_SUPERVISOR"
+
+// superviseIfRequested runs the shared test server supervisor and exits if this process was
+// started as one by startSharedSupervisor. It is called by RunTests before running the tests.
+func superviseIfRequested() {
+	if v := os.Getenv(sharedSupervisorEnv); v != "" {
+		os.Exit(runSharedSupervisor(v))
+	}
+}
+
+// lockSharedState takes an exclusive lock on the state file, released by closing the returned file
+func lockSharedState(fn string) (*os.File, error) {
+	f, err := os.OpenFile(fn+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// pidAlive returns true if a process with the given pid exists
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// attachSharedTestServer returns the DSN of the shared test server for opts, starting it if
+// needed. release must be called once the server isn't needed anymore.
+func attachSharedTestServer(opts TestServerOptions) (dsn string, release func() error, err error) {
+	if !runningTests {
+		// the supervisor is a copy of this binary, and only RunTests knows to run it
+		return "", nil, errors.New("shared test server requires RunTests in TestMain")
+	}
+
+	cfg := newSharedConfig(opts)
+	fn := cfg.stateFile()
+	os.MkdirAll(cachePath(), 0755)
+	self := os.Getpid()
+
+	release = func() error {
+		lk, err := lockSharedState(fn)
+		if err != nil {
+			return err
+		}
+		defer lk.Close()
+
+		st, err := readSharedState(fn)
+		if err != nil || st == nil {
+			return err
+		}
+		st.Users = slices.DeleteFunc(st.Users, func(pid int) bool { return pid == self })
+		if len(st.Users) == 0 {
+			st.Idle = time.Now()
+		}
+		return writeSharedState(fn, st)
+	}
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		dsn, err := attachSharedStep(cfg, fn, self)
+		if err != nil {
+			// we may have been registered before failing
+			release()
+			return "", nil, err
+		}
+		if dsn != "" {
+			return dsn, release, nil
+		}
+		if time.Now().After(deadline) {
+			release()
+			return "", nil, errors.New("timeout waiting for shared test server to start")
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// attachSharedStep registers self as a user of the shared server, starting it if needed, and
+// returns its DSN if it is ready
+func attachSharedStep(cfg *sharedConfig, fn string, self int) (string, error) {
+	lk, err := lockSharedState(fn)
+	if err != nil {
+		return "", err
+	}
+	defer lk.Close()
+
+	st, err := readSharedState(fn)
+	if err != nil {
+		return "", err
+	}
+	if st != nil && st.Error != "" {
+		// failed to start, let the next attempt start over
+		writeSharedState(fn, nil)
+		return "", fmt.Errorf("shared test server failed to start: %s", st.Error)
+	}
+	if st == nil || !pidAlive(st.Pid) {
+		pid, err := startSharedSupervisor(cfg, fn)
+		if err != nil {
+			return "", err
+		}
+		st = &sharedState{Pid: pid}
+	}
+
+	if !slices.Contains(st.Users, self) {
+		st.Users = append(st.Users, self)
+		st.Idle = time.Time{}
+		if err := writeSharedState(fn, st); err != nil {
+			return "", err
+		}
+	}
+	return st.DSN, nil
+}
+
+// startSharedSupervisor runs the current executable as a detached supervisor process, which
+// starts the server and stops it once it isn't used anymore
+func startSharedSupervisor(cfg *sharedConfig, fn string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	enc, err := json.Marshal(cfg)
+	if err != nil {
+		return 0, err
+	}
+	logf, err := os.Create(strings.TrimSuffix(fn, ".json") + ".log")
+	if err != nil {
+		return 0, err
+	}
+	defer logf.Close()
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), sharedSupervisorEnv+"="+string(enc))
+	cmd.Stdout = logf
+	cmd.Stderr = logf
+	// new session so the supervisor survives the test binary
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start shared test server supervisor: %w", err)
+	}
+	// reap the process if it ends while we are still running
+	go cmd.Wait()
+
+	return cmd.Process.Pid, nil
+}
+
+// runSharedSupervisor starts the shared test server and keeps it running while it has users
+func runSharedSupervisor(v string) int {
+	cfg := &sharedConfig{}
+	if err := json.Unmarshal([]byte(v), cfg); err != nil {
+		log.Printf("[froach] invalid supervisor configuration: %s", err)
+		return 1
+	}
+	fn := cfg.stateFile()
+	self := os.Getpid()
+
+	// update runs cb with the current state if it is still ours, and writes it back
+	update := func(cb func(st *sharedState) bool) bool {
+		lk, err := lockSharedState(fn)
+		if err != nil {
+			log.Printf("[froach] failed to lock shared state: %s", err)
+			return false
+		}
+		defer lk.Close()
+
+		st, err := readSharedState(fn)
+		if err != nil || st == nil || st.Pid != self {
+			// another supervisor took over
+			return false
+		}
+		if !cb(st) {
+			writeSharedState(fn, nil)
+			return false
+		}
+		writeSharedState(fn, st)
+		return true
+	}
+
+	opts := cfg.options()
+	pi, dsn, err := launchLocalTestServer(opts)
+	if err == nil {
+		if err = opts.setup(dsn); err != nil {
+			pi.stop()
+		}
+	}
+	if err != nil {
+		log.Printf("[froach] failed to start shared test server: %s", err)
+		update(func(st *sharedState) bool {
+			st.Error = err.Error()
+			return true
+		})
+		return 1
+	}
+	defer pi.stop()
+
+	if !update(func(st *sharedState) bool {
+		st.DSN = dsn
+		return true
+	}) {
+		return 0
+	}
+	log.Printf("[froach] shared test server running at %s", dsn)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-pi.done:
+			log.Printf("[froach] shared test server ended: %s", pi.exitErr)
+			update(func(st *sharedState) bool { return false })
+			return 1
+		case <-t.C:
+		}
+
+		running := update(func(st *sharedState) bool {
+			st.pruneUsers(pidAlive)
+			if len(st.Users) > 0 {
+				st.Idle = time.Time{}
+				return true
+			}
+			if st.Idle.IsZero() {
+				st.Idle = time.Now()
+			}
+			return time.Since(st.Idle) < cfg.Idle
+		})
+		if !running {
+			log.Printf("[froach] stopping idle shared test server")
+			return 0
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"slices"
+)
+
+// ErrNoCA is returned when the CA private key has not been received yet
+var ErrNoCA = errors.New("froach CA is not available yet")
+
+// ErrCATemplate is returned by SignCertificate and SignClientCertificate when asked to sign a CA
+var ErrCATemplate = errors.New("froach only signs leaf certificates")
+
+// constrainedExtensions are the extensions set by leafTemplate, which may not be overridden through
+// ExtraExtensions: basic constraints, key usage and extended key usage
+var constrainedExtensions = []asn1.ObjectIdentifier{{2, 5, 29, 19}, {2, 5, 29, 15}, {2, 5, 29, 37}}
+
+// CACertificate returns the current CA certificate of this host
+func CACertificate() (*x509.Certificate, error) {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	if nodeCA.crt == nil {
+		return nil, ErrNoCA
+	}
+	return nodeCA.crt, nil
+}
+
+// SignCertificate signs the given certificate template for pub with the fleet CA and returns the
+// resulting certificate in DER form. This is meant for tools that would otherwise need ca.key
+// (such as "cockroach cert"), since the CA private key is never written to disk.
+//
+// Only leaf certificates are signed: key usages are set to those of node certificates, which
+// can be used both as server and client.
+func SignCertificate(tpl *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	tpl, err := leafTemplate(tpl, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return nodeCA.sign(tpl, pub)
+}
+
+// SignClientCertificate is similar to SignCertificate but uses the client CA if split PKI is
+// enabled, and the resulting certificate can only be used for client authentication.
+func SignClientCertificate(tpl *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	tpl, err := leafTemplate(tpl, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return clientAuthority().sign(tpl, pub)
+}
+
+// leafTemplate returns a copy of tpl restricted to a leaf certificate with the given extended key
+// usages, or ErrCATemplate if tpl is a CA
+func leafTemplate(tpl *x509.Certificate, ext ...x509.ExtKeyUsage) (*x509.Certificate, error) {
+	if tpl.IsCA {
+		return nil, ErrCATemplate
+	}
+
+	t := *tpl
+	t.BasicConstraintsValid = true
+	t.MaxPathLen = 0
+	t.MaxPathLenZero = false
+	t.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyEncipherment
+	t.ExtKeyUsage = ext
+	t.UnknownExtKeyUsage = nil
+	t.ExtraExtensions = slices.DeleteFunc(slices.Clone(t.ExtraExtensions), func(e pkix.Extension) bool {
+		return slices.ContainsFunc(constrainedExtensions, e.Id.Equal)
+	})
+	return &t, nil
+}
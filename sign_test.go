@@ -0,0 +1,106 @@
+package froach
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSignCertificate(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tool"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	ca := *tpl
+	ca.IsCA = true
+	ca.BasicConstraintsValid = true
+	ca.KeyUsage = x509.KeyUsageCertSign
+	if _, err := SignCertificate(&ca, key.Public()); !errors.Is(err, ErrCATemplate) {
+		t.Errorf("expected CA template to be rejected, got %v", err)
+	}
+
+	// a basic constraints extension marking the certificate as a CA
+	bc, _ := asn1.Marshal(struct {
+		IsCA bool
+	}{true})
+	leaf := *tpl
+	leaf.KeyUsage = x509.KeyUsageCertSign
+	leaf.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	leaf.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: bc}}
+	bin, err := SignCertificate(&leaf, key.Public())
+	if err != nil {
+		t.Fatalf("failed to sign certificate: %s", err)
+	}
+	crt, err := x509.ParseCertificate(bin)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	if crt.IsCA || crt.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.Errorf("signed certificate can sign other certificates")
+	}
+	if !slices.Equal(crt.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("unexpected extended key usages %v", crt.ExtKeyUsage)
+	}
+}
+
+func TestSignClientCertificate(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+	clientCA.watchKey(updateClientKey)
+	if err := EnableSplitPKI(); err != nil {
+		t.Fatalf("failed to enable split PKI: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	if _, err := SignClientCertificate(tpl, key.Public()); !errors.Is(err, ErrCATemplate) {
+		t.Errorf("expected CA template to be rejected, got %v", err)
+	}
+
+	tpl.IsCA = false
+	tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	bin, err := SignClientCertificate(tpl, key.Public())
+	if err != nil {
+		t.Fatalf("failed to sign certificate: %s", err)
+	}
+	crt, err := x509.ParseCertificate(bin)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	if !slices.Equal(crt.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("unexpected extended key usages %v", crt.ExtKeyUsage)
+	}
+	keyLk.Lock()
+	ca := clientCA.crt
+	keyLk.Unlock()
+	if err := crt.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("certificate not signed by the client CA: %s", err)
+	}
+}
@@ -0,0 +1,98 @@
+package froach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// spatialLibDir returns the directory containing the GEOS libraries for the given cockroach
+// binary, or an empty string if these could not be found. Archives from cockroach servers have
+// these in lib/ next to the binary, while azusa installs have them in ../lib relative to bin/.
+func spatialLibDir(exe string) string {
+	if real, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = real
+	}
+	dir := filepath.Dir(exe)
+
+	candidates := []string{
+		filepath.Join(dir, "lib"),
+		filepath.Join(dir, "..", "lib"),
+	}
+
+	for _, c := range candidates {
+		if hasGeosLibs(c) {
+			return filepath.Clean(c)
+		}
+	}
+	return ""
+}
+
+// hasGeosLibs returns true if all the GEOS libraries can be found in dir
+func hasGeosLibs(dir string) bool {
+	for _, lib := range geosLibs() {
+		if _, err := os.Stat(filepath.Join(dir, lib)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// spatialArgs returns the cockroach arguments needed to enable spatial features
+func spatialArgs(libDir string) []string {
+	if libDir == "" {
+		return nil
+	}
+	return []string{"--spatial-libs=" + libDir}
+}
+
+// errSpatialUnavailable is returned by checkSpatial if the server is reachable but the spatial
+// query failed
+var errSpatialUnavailable = errors.New("spatial features unavailable")
+
+// checkSpatial runs a spatial query on the given server to confirm GEOS was loaded
+func checkSpatial(dsn string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer c.Close(context.Background())
+
+	// ST_Buffer requires GEOS, unlike simpler functions such as ST_MakePoint
+	_, err = c.Exec(ctx, "SELECT ST_AsText(ST_Buffer(ST_MakePoint(1, 2), 1))").ReadAll()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSpatialUnavailable, err)
+	}
+	return nil
+}
+
+// spatialChecked is set once checkSpatial ran against the local node, only accessed from monitor()
+var spatialChecked bool
+
+// checkNodeSpatial confirms spatial features work on the running local node and updates
+// Status.Spatial accordingly
+func checkNodeSpatial() {
+	if spatialChecked {
+		return
+	}
+
+	err := checkSpatial(rootDSN())
+	if errors.Is(err, errSpatialUnavailable) {
+		slog.Warn(fmt.Sprintf("[froach] %s", err), "event", "froach:spatial:unavailable")
+	} else if err != nil {
+		// cockroach may still be starting, or the cluster not initialized yet
+		slog.Debug(fmt.Sprintf("[froach] failed to connect to check spatial features: %s", err), "event", "froach:spatial:connect_fail")
+		return
+	}
+	spatialChecked = true
+	updateStatus(func(s *Status) { s.Spatial = err == nil })
+}
@@ -0,0 +1,44 @@
+package froach
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+)
+
+// EnableSplitPKI enables split PKI mode on the whole fleet. A separate client CA is generated
+// and shared through fleet DB, and all hosts will write ca-client.crt, client.node.crt and ui.crt
+// in their certs dir. Client certificates will then be issued by the client CA, so a leaked
+// client CA cannot be used to impersonate nodes.
+func EnableSplitPKI() error {
+	ref, err := clientCA.keyRef()
+	if err != nil {
+		return err
+	}
+	if ref != "" {
+		// already enabled
+		return nil
+	}
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	// this will trigger the watcher, that will call updateClientKey accordingly
+	return clientCA.storeKey(newKey)
+}
+
+// DisableSplitPKI disables split PKI mode on the whole fleet, and client certificates will be
+// issued by the node CA again.
+func DisableSplitPKI() error {
+	return clientCA.removeKey()
+}
+
+// SplitPKI returns true if split PKI mode is enabled on this host
+func SplitPKI() bool {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return clientCA.ready()
+}
@@ -0,0 +1,48 @@
+package froach
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitPKI(t *testing.T) {
+	testFleet(t)
+	testStartFleetDB(t)
+	clientCA.watchKey(updateClientKey)
+
+	if err := EnableSplitPKI(); err != nil {
+		t.Fatalf("failed to enable split PKI: %s", err)
+	}
+	if !SplitPKI() {
+		t.Fatalf("split PKI not enabled")
+	}
+	for _, fn := range []string{"ca-client.crt", "client.node.crt", "ui.crt"} {
+		if _, err := os.Stat(filepath.Join(basePath(), fn)); err != nil {
+			t.Errorf("%s missing with split PKI: %s", fn, err)
+		}
+	}
+	first := authorityKey(clientCA)
+
+	if err := DisableSplitPKI(); err != nil {
+		t.Fatalf("failed to disable split PKI: %s", err)
+	}
+	if SplitPKI() {
+		t.Fatalf("split PKI still enabled")
+	}
+	for _, fn := range []string{"ca-client.crt", "client.node.crt", "ui.crt"} {
+		if _, err := os.Stat(filepath.Join(basePath(), fn)); err == nil {
+			t.Errorf("%s still exists without split PKI", fn)
+		}
+	}
+
+	if err := EnableSplitPKI(); err != nil {
+		t.Fatalf("failed to enable split PKI again: %s", err)
+	}
+	if !SplitPKI() {
+		t.Fatalf("split PKI not enabled again")
+	}
+	if sameKey(first, authorityKey(clientCA)) {
+		t.Errorf("client CA key was reused after disabling split PKI")
+	}
+}
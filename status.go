@@ -0,0 +1,33 @@
+package froach
+
+import "sync"
+
+// Status describes the state of the local cockroach node as managed by froach
+type Status struct {
+	Exe         string `json:"exe,omitempty"`          // cockroach binary used to run the node
+	SpatialLibs string `json:"spatial_libs,omitempty"` // directory passed as --spatial-libs
+	Spatial     bool   `json:"spatial"`                // true if a spatial query succeeded on the local node
+
+	Rotation *RotationStatus `json:"rotation,omitempty"` // CA rotation in progress, if any
+}
+
+var (
+	statusLk sync.RWMutex
+	status   Status
+)
+
+// GetStatus returns a copy of the current froach status
+func GetStatus() Status {
+	statusLk.RLock()
+	defer statusLk.RUnlock()
+
+	return status
+}
+
+// updateStatus calls cb with the status locked for writing
+func updateStatus(cb func(s *Status)) {
+	statusLk.Lock()
+	defer statusLk.Unlock()
+
+	cb(&status)
+}
@@ -0,0 +1,40 @@
+package froach
+
+import "github.com/KarpelesLab/fleet"
+
+// fleetStore is the part of the fleet agent froach uses to share state between hosts. Tests
+// replace it, since fleet.Self() waits for the fleet to be configured.
+type fleetStore interface {
+	Id() string
+	AltNames() []string
+	DbGet(key string) ([]byte, error)
+	DbSet(key string, value []byte) error
+	DbDelete(key string) error
+	DbWatch(key string, cb func(string, []byte))
+	DbList(prefix string) (map[string][]byte, error)
+}
+
+// store returns the fleetStore in use, which is fleet.Self() unless replaced by tests
+var store = func() fleetStore {
+	return agentStore{fleet.Self()}
+}
+
+// agentStore adds DbList to fleet.Agent
+type agentStore struct {
+	*fleet.Agent
+}
+
+// DbList returns the values of all keys starting with prefix, by key without the prefix
+func (s agentStore) DbList(prefix string) (map[string][]byte, error) {
+	c, err := s.NewDbCursor([]byte("app"))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	res := make(map[string][]byte)
+	for k, v := c.Seek([]byte(prefix)); k != nil; k, v = c.Next() {
+		res[string(k)] = v
+	}
+	return res, nil
+}
@@ -0,0 +1,125 @@
+package froach
+
+import (
+	"crypto"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStore is a fleetStore keeping data in memory, with the same handling of keys ending with
+// "!" as fleet DB
+type memStore struct {
+	lk    sync.Mutex
+	data  map[string][]byte
+	watch map[string][]func(string, []byte)
+}
+
+func (s *memStore) Id() string {
+	return "test-host"
+}
+
+func (s *memStore) AltNames() []string {
+	return []string{"test-host.example.com"}
+}
+
+func (s *memStore) DbGet(key string) ([]byte, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	v, ok := s.data[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return v, nil
+}
+
+func (s *memStore) DbSet(key string, value []byte) error {
+	s.lk.Lock()
+	if _, ok := s.data[key]; ok && strings.HasSuffix(key, "!") {
+		// keys ending with ! keep their first value
+		s.lk.Unlock()
+		return nil
+	}
+	if value == nil {
+		delete(s.data, key)
+	} else {
+		s.data[key] = value
+	}
+	cbs := s.watch[key]
+	s.lk.Unlock()
+
+	for _, cb := range cbs {
+		cb(key, value)
+	}
+	return nil
+}
+
+func (s *memStore) DbDelete(key string) error {
+	return s.DbSet(key, nil)
+}
+
+func (s *memStore) DbWatch(key string, cb func(string, []byte)) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.watch[key] = append(s.watch[key], cb)
+}
+
+func (s *memStore) DbList(prefix string) (map[string][]byte, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	res := make(map[string][]byte)
+	for k, v := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			res[k[len(prefix):]] = v
+		}
+	}
+	return res, nil
+}
+
+// testFleet replaces the fleet with a memStore for the duration of the test, and writes
+// certificates to a temporary directory
+func testFleet(t *testing.T) *memStore {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	s := &memStore{data: make(map[string][]byte), watch: make(map[string][]func(string, []byte))}
+	oldStore, oldBackend := store, getCABackend()
+	store = func() fleetStore { return s }
+
+	keyLk.Lock()
+	oldNode, oldClient, oldNext := *nodeCA, *clientCA, *nextCA
+	keyLk.Unlock()
+
+	t.Cleanup(func() {
+		store = oldStore
+		rotateStage = ""
+		SetCABackend(oldBackend)
+		keyLk.Lock()
+		*nodeCA, *clientCA, *nextCA = oldNode, oldClient, oldNext
+		keyLk.Unlock()
+	})
+	return s
+}
+
+// testStartFleetDB starts a FleetDBBackend as start() would
+func testStartFleetDB(t *testing.T) {
+	b := &fleetDBBackend{}
+	SetCABackend(b)
+	if err := b.Start(setCAKey); err != nil {
+		t.Fatalf("failed to start backend: %s", err)
+	}
+	if authorityKey(nodeCA) == nil {
+		t.Fatalf("node CA has no key")
+	}
+}
+
+// authorityKey returns the current key of the authority
+func authorityKey(a *authority) crypto.Signer {
+	keyLk.Lock()
+	defer keyLk.Unlock()
+
+	return a.key
+}
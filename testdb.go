@@ -0,0 +1,258 @@
+package froach
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TestDB is a database created for a single test by NewTestDB
+type TestDB struct {
+	Name string // name of the database
+	User string // user owning the database if TestDBOptions.User was set
+	DSN  string // DSN connecting to the database (as User if set)
+
+	adminDsn string // DSN of the server, connecting as root
+	pool     *pgxpool.Pool
+	poolErr  error
+	poolLk   sync.Mutex
+	dropped  bool // set by drop, protected by poolLk
+	snapLk   sync.Mutex
+	snaps    int // number of snapshots taken, used to name these
+}
+
+// TestDBOptions are options for NewTestDBWithOptions
+type TestDBOptions struct {
+	User   bool  // create a user with the same name as the database owning it, and use it in DSN
+	Schema fs.FS // .sql files applied in lexical order when creating the database
+	Seed   fs.FS // .sql files applied in lexical order after Schema
+}
+
+// NewTestDB creates a uniquely named database on the local test server (see LocalTestServer),
+// which is dropped once the test completes. Since each test gets its own database, tests can
+// safely use t.Parallel(). The test is skipped if the test server cannot be started.
+func NewTestDB(t testing.TB) *TestDB {
+	t.Helper()
+	return NewTestDBWithOptions(t, TestDBOptions{})
+}
+
+// NewTestDBWithOptions is similar to NewTestDB but accepts options
+func NewTestDBWithOptions(t testing.TB, opts TestDBOptions) *TestDB {
+	t.Helper()
+
+	dsn, err := LocalTestServer()
+	if err != nil {
+		t.Skipf("unable to launch cockroach: %s", err)
+		return nil
+	}
+
+	db, err := createTestDB(dsn, testDBName(t.Name()), opts)
+	if err != nil {
+		t.Fatalf("failed to create test database: %s", err)
+		return nil
+	}
+	t.Cleanup(func() {
+		if err := db.drop(); err != nil {
+			t.Logf("failed to drop test database %s: %s", db.Name, err)
+		}
+	})
+	return db
+}
+
+// Pool returns a connection pool for the database, which is closed when the test completes
+func (db *TestDB) Pool() (*pgxpool.Pool, error) {
+	db.poolLk.Lock()
+	defer db.poolLk.Unlock()
+
+	if db.pool == nil && db.poolErr == nil {
+		if db.dropped {
+			return nil, errors.New("test database was dropped")
+		}
+		db.pool, db.poolErr = pgxpool.New(context.Background(), db.DSN)
+	}
+	return db.pool, db.poolErr
+}
+
+// currentPool returns the pool if Pool was called, and marks the database as dropped if drop is
+// set so Pool doesn't create a new one
+func (db *TestDB) currentPool(drop bool) *pgxpool.Pool {
+	db.poolLk.Lock()
+	defer db.poolLk.Unlock()
+
+	if drop {
+		db.dropped = true
+	}
+	return db.pool
+}
+
+// testDBName returns a unique database name based on the test name
+func testDBName(testName string) string {
+	var b strings.Builder
+	b.WriteString("test_")
+	for _, c := range strings.ToLower(testName) {
+		if b.Len() >= 40 {
+			break
+		}
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+
+	rnd := make([]byte, 6)
+	rand.Read(rnd)
+	b.WriteByte('_')
+	b.WriteString(hex.EncodeToString(rnd))
+	return b.String()
+}
+
+// createTestDB creates the database (and user) on the server at dsn
+func createTestDB(dsn, name string, opts TestDBOptions) (*TestDB, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []string
+	if opts.Schema != nil || opts.Seed != nil {
+		if err := createFromTemplate(dsn, name, opts.Schema, opts.Seed); err != nil {
+			return nil, err
+		}
+	} else {
+		queries = append(queries, fmt.Sprintf("CREATE DATABASE %s", ident(name)))
+	}
+	if opts.User {
+		queries = append(queries,
+			fmt.Sprintf("CREATE USER %s", ident(name)),
+			fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", ident(name), ident(name)),
+			fmt.Sprintf("GRANT ALL ON TABLE %s.* TO %s", ident(name), ident(name)),
+		)
+		u.User = url.User(name)
+	}
+	if err := execSQL(dsn, queries...); err != nil {
+		return nil, err
+	}
+
+	u.Path = "/" + name
+	db := &TestDB{
+		Name:     name,
+		DSN:      u.String(),
+		adminDsn: dsn,
+	}
+	if opts.User {
+		db.User = name
+	}
+	return db, nil
+}
+
+// drop closes the pool if any and drops the database and its user
+func (db *TestDB) drop() error {
+	if p := db.currentPool(true); p != nil {
+		p.Close()
+	}
+
+	queries := []string{fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", ident(db.Name))}
+	if db.User != "" {
+		queries = append(queries, fmt.Sprintf("DROP USER IF EXISTS %s", ident(db.User)))
+	}
+	return execSQL(db.adminDsn, queries...)
+}
+
+// ident quotes an SQL identifier such as a database or user name
+func ident(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// literal quotes an SQL string literal
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// execSQL runs the given queries in order on the server at dsn
+func execSQL(dsn string, queries ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer c.Close(context.Background())
+
+	for _, q := range queries {
+		if _, err := c.Exec(ctx, q).ReadAll(); err != nil {
+			return fmt.Errorf("%s: %w", q, err)
+		}
+	}
+	return nil
+}
+
+// TestSnapshot is the state of a test database recorded by TestDB.Snapshot
+type TestSnapshot struct {
+	db  *TestDB
+	loc string
+}
+
+// Snapshot records the current state of the database so it can be reset to it later with Restore,
+// for example between subtests
+func (db *TestDB) Snapshot() (*TestSnapshot, error) {
+	db.snapLk.Lock()
+	defer db.snapLk.Unlock()
+
+	db.snaps++
+	loc := fmt.Sprintf("nodelocal://1/froach/%s/%d", db.Name, db.snaps)
+	if err := execSQL(db.adminDsn, fmt.Sprintf("BACKUP DATABASE %s INTO %s", ident(db.Name), literal(loc))); err != nil {
+		return nil, err
+	}
+	return &TestSnapshot{db: db, loc: loc}, nil
+}
+
+// Restore resets the database to the state recorded in the snapshot. Connections from Pool are
+// reset, other open connections should not be used anymore.
+func (db *TestDB) Restore(s *TestSnapshot) error {
+	if s == nil || s.db == nil {
+		return errors.New("invalid snapshot")
+	}
+	if s.db != db {
+		return errors.New("snapshot was taken from another database")
+	}
+
+	db.snapLk.Lock()
+	defer db.snapLk.Unlock()
+
+	// restore under a temporary name, then swap it with the current database
+	tmp := db.Name + "_restore"
+	queries := []string{
+		fmt.Sprintf("DROP DATABASE IF EXISTS %s CASCADE", ident(tmp)),
+		fmt.Sprintf("RESTORE DATABASE %s FROM LATEST IN %s WITH new_db_name = %s", ident(db.Name), literal(s.loc), literal(tmp)),
+		fmt.Sprintf("DROP DATABASE %s CASCADE", ident(db.Name)),
+		fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", ident(tmp), ident(db.Name)),
+	}
+	if db.User != "" {
+		queries = append(queries,
+			fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", ident(db.Name), ident(db.User)),
+			fmt.Sprintf("GRANT ALL ON TABLE %s.* TO %s", ident(db.Name), ident(db.User)),
+		)
+	}
+	if err := execSQL(db.adminDsn, queries...); err != nil {
+		return err
+	}
+
+	if p := db.currentPool(false); p != nil {
+		p.Reset()
+	}
+	return nil
+}
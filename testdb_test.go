@@ -0,0 +1,62 @@
+package froach
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestTestDBName(t *testing.T) {
+	valid := regexp.MustCompile(`^test_[a-z0-9_]+_[0-9a-f]{12}$`)
+
+	a := testDBName("TestSomething/sub-test #1")
+	b := testDBName("TestSomething/sub-test #1")
+	if !valid.MatchString(a) {
+		t.Errorf("invalid database name %q", a)
+	}
+	if a == b {
+		t.Errorf("database names should be unique, got %q twice", a)
+	}
+
+	long := testDBName("TestAVeryLongTestNameThatWouldNotFitInAnIdentifierWithoutTruncation/AndASubTest")
+	if len(long) > 63 || !valid.MatchString(long) {
+		t.Errorf("invalid database name %q", long)
+	}
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	db := &TestDB{Name: "test"}
+	for _, s := range []*TestSnapshot{nil, {}, {db: &TestDB{Name: "other"}}} {
+		if err := db.Restore(s); err == nil {
+			t.Errorf("restoring %+v should fail", s)
+		}
+	}
+}
+
+func TestPoolDrop(t *testing.T) {
+	// nothing listens there, so dropping fails but the pool is still closed
+	dsn := "postgresql://root@127.0.0.1:1/defaultdb?sslmode=disable&connect_timeout=1"
+	db := &TestDB{Name: "test", DSN: dsn, adminDsn: dsn}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		db.Pool()
+	}()
+	db.drop() // races with Pool, see go test -race
+	<-done
+
+	db = &TestDB{Name: "test", DSN: dsn, adminDsn: dsn}
+	db.drop()
+	if _, err := db.Pool(); err == nil {
+		t.Errorf("expected Pool to fail after drop")
+	}
+}
+
+func TestQuoting(t *testing.T) {
+	if v := ident(`my "db"`); v != `"my ""db"""` {
+		t.Errorf("unexpected identifier %s", v)
+	}
+	if v := literal("it's"); v != `'it''s'` {
+		t.Errorf("unexpected literal %s", v)
+	}
+}
@@ -0,0 +1,182 @@
+package froach
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// verifiedMarker is written in a cockroach directory once its content passed validation
+const verifiedMarker = ".froach-verified"
+
+// verifiedInfo is stored in verifiedMarker. The size and modification time of the binary are
+// recorded so it gets validated again if it is replaced or truncated.
+type verifiedInfo struct {
+	Version string    `json:"version"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// exeInfo contains the information reported by "cockroach version"
+type exeInfo struct {
+	BuildTag string // v24.1.0
+	Platform string // linux-amd64
+}
+
+// geosLibs returns the filenames of the GEOS libraries shipped in the lib/ directory of
+// cockroachdb archives for the current OS
+func geosLibs() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"libgeos.dylib", "libgeos_c.dylib"}
+	case "windows":
+		return []string{"geos.dll", "geos_c.dll"}
+	default:
+		return []string{"libgeos.so", "libgeos_c.so"}
+	}
+}
+
+// Validate checks that the cockroach version extracted in dirname (typically in a subdirectory
+// named v.Dirname()) is complete and runs on this machine with the expected version.
+func (v *CockroachVersion) Validate(dirname string) error {
+	dir := filepath.Join(dirname, v.Dirname())
+
+	for _, lib := range geosLibs() {
+		if _, err := os.Stat(filepath.Join(dir, "lib", lib)); err != nil {
+			return fmt.Errorf("missing bundled library: %w", err)
+		}
+	}
+
+	if v.verified(dir) {
+		// already validated
+		return nil
+	}
+
+	exe := filepath.Join(dir, "cockroach")
+	nfo, err := runVersion(exe)
+	if err != nil {
+		return err
+	}
+	if nfo.BuildTag != v.Version() {
+		return fmt.Errorf("unexpected version %s (expected %s)", nfo.BuildTag, v.Version())
+	}
+	if nfo.Platform != v.Platform() {
+		return fmt.Errorf("unexpected platform %s (expected %s)", nfo.Platform, v.Platform())
+	}
+
+	// remember that this directory is fine so we don't need to run it again
+	if st, err := os.Stat(exe); err == nil {
+		if dat, err := json.Marshal(&verifiedInfo{Version: nfo.BuildTag, Size: st.Size(), ModTime: st.ModTime()}); err == nil {
+			os.WriteFile(filepath.Join(dir, verifiedMarker), dat, 0644)
+		}
+	}
+	return nil
+}
+
+// validateSystem checks that a cockroach binary installed by the system (such as azusa) has its
+// GEOS libraries and runs on this machine. Unlike Validate, its version isn't checked since the
+// system package manager decides which version is installed.
+func validateSystem(exe string) error {
+	if spatialLibDir(exe) == "" {
+		return errors.New("missing GEOS libraries")
+	}
+	nfo, err := runVersion(exe)
+	if err != nil {
+		return err
+	}
+	if p := runtime.GOOS + "-" + runtime.GOARCH; nfo.Platform != p {
+		return fmt.Errorf("unexpected platform %s (expected %s)", nfo.Platform, p)
+	}
+	return nil
+}
+
+// verified returns true if the cockroach binary in dir passed validation and wasn't modified since
+func (v *CockroachVersion) verified(dir string) bool {
+	dat, err := os.ReadFile(filepath.Join(dir, verifiedMarker))
+	if err != nil {
+		return false
+	}
+	nfo := &verifiedInfo{}
+	if err := json.Unmarshal(dat, nfo); err != nil || nfo.Version != v.Version() {
+		// markers written by previous versions only contained the version
+		return false
+	}
+	st, err := os.Stat(filepath.Join(dir, "cockroach"))
+	if err != nil {
+		return false
+	}
+	return st.Size() == nfo.Size && st.ModTime().Equal(nfo.ModTime)
+}
+
+// quarantine moves a failed install out of the way so it can be fetched again, keeping it
+// around for inspection
+func (v *CockroachVersion) quarantine(dirname string) {
+	dir := filepath.Join(dirname, v.Dirname())
+	target := fmt.Sprintf("%s.bad-%d", dir, time.Now().Unix())
+
+	if err := os.Rename(dir, target); err != nil {
+		slog.Error(fmt.Sprintf("[froach] failed to quarantine %s, removing it: %s", dir, err), "event", "froach:exe:quarantine_fail")
+		os.RemoveAll(dir)
+		return
+	}
+	slog.Warn(fmt.Sprintf("[froach] moved invalid cockroach install to %s", target), "event", "froach:exe:quarantine")
+}
+
+// runVersion runs "cockroach version" and parses its output
+func runVersion(exe string) (*exeInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, exe, "version").Output()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("cockroach version timed out: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to run cockroach version: %w", err)
+	}
+
+	return parseVersion(out)
+}
+
+// parseVersion parses the output of "cockroach version", which looks like:
+//
+// Build Tag:        v24.1.0
+// Platform:         linux amd64 (x86_64-pc-linux-gnu)
+// ...
+func parseVersion(out []byte) (*exeInfo, error) {
+	res := &exeInfo{}
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		k, val, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+
+		switch strings.TrimSpace(k) {
+		case "Build Tag":
+			res.BuildTag = val
+		case "Platform":
+			// linux amd64 (x86_64-pc-linux-gnu)
+			if f := strings.Fields(val); len(f) >= 2 {
+				res.Platform = f[0] + "-" + f[1]
+			}
+		}
+	}
+
+	if res.BuildTag == "" {
+		return nil, fmt.Errorf("unexpected output from cockroach version: %s", out)
+	}
+	return res, nil
+}
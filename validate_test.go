@@ -0,0 +1,113 @@
+package froach
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	out := []byte(`Build Tag:        v24.1.0
+Build Time:       2024/05/15 21:28:29
+Distribution:     CCL
+Platform:         linux amd64 (x86_64-pc-linux-gnu)
+Go Version:       go1.22.2 X:nocoverageredesign
+C Compiler:       gcc 6.5.0
+Build Commit ID:  e8b6ea4e6e3a37e0ff0ad5e7c4f1bd5a1b8d2b73
+Build Type:       release
+Enabled Assertions: false
+`)
+
+	nfo, err := parseVersion(out)
+	if err != nil {
+		t.Fatalf("failed to parse version: %s", err)
+	}
+	if nfo.BuildTag != "v24.1.0" || nfo.Platform != "linux-amd64" {
+		t.Errorf("unexpected version info %+v", nfo)
+	}
+
+	if _, err := parseVersion([]byte("segmentation fault")); err == nil {
+		t.Errorf("expected error on invalid output")
+	}
+}
+
+func TestVersionFilename(t *testing.T) {
+	v := &CockroachVersion{Filename: "cockroach-v24.1.0-beta.2.linux-arm64.tgz"}
+
+	if v.Version() != "v24.1.0-beta.2" {
+		t.Errorf("unexpected version %s", v.Version())
+	}
+	if v.Platform() != "linux-arm64" {
+		t.Errorf("unexpected platform %s", v.Platform())
+	}
+}
+
+func TestVerified(t *testing.T) {
+	v := &CockroachVersion{Filename: "cockroach-v24.1.0.linux-amd64.tgz"}
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "cockroach")
+
+	if err := os.WriteFile(exe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dat, _ := json.Marshal(&verifiedInfo{Version: "v24.1.0", Size: st.Size(), ModTime: st.ModTime()})
+	if err := os.WriteFile(filepath.Join(dir, verifiedMarker), dat, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !v.verified(dir) {
+		t.Errorf("expected binary to be verified")
+	}
+
+	// truncated binary
+	if err := os.Truncate(exe, 2); err != nil {
+		t.Fatal(err)
+	}
+	if v.verified(dir) {
+		t.Errorf("expected truncated binary to need validation")
+	}
+
+	// marker written by previous versions
+	if err := os.WriteFile(filepath.Join(dir, verifiedMarker), []byte("v24.1.0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v.verified(dir) {
+		t.Errorf("expected legacy marker to need validation")
+	}
+}
+
+func TestValidateSystem(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("system installs are only used on linux")
+	}
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "bin", "cockroach")
+	script := "#!/bin/sh\necho 'Build Tag:        v24.1.0'\necho 'Platform:         linux " + runtime.GOARCH + " (test)'\n"
+	if err := os.MkdirAll(filepath.Dir(exe), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateSystem(exe); err == nil {
+		t.Errorf("expected missing GEOS libraries to fail validation")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, lib := range geosLibs() {
+		if err := os.WriteFile(filepath.Join(dir, "lib", lib), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := validateSystem(exe); err != nil {
+		t.Errorf("expected system install to be valid: %s", err)
+	}
+}
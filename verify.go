@@ -0,0 +1,83 @@
+package froach
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+)
+
+// certProblem checks that a certificate and its private key are valid for one of the given CAs,
+// common name and alt names. It returns a description of the first problem found, or an empty
+// string if everything is fine.
+func certProblem(crt *x509.Certificate, key crypto.PrivateKey, cas []*x509.Certificate, cn string, altNames []string) string {
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca)
+	}
+
+	_, err := crt.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Sprintf("certificate verification failed: %s", err)
+	}
+
+	if crt.Subject.CommonName != cn {
+		return fmt.Sprintf("unexpected common name %q (expected %q)", crt.Subject.CommonName, cn)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Sprintf("unsupported private key type %T", key)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(crt.PublicKey) {
+		return "private key does not match certificate"
+	}
+
+	has := crtAltNames(crt)
+	want := normalizeAltNames(altNames)
+	if !slices.Equal(has, want) {
+		return fmt.Sprintf("alt names changed from %v to %v", has, want)
+	}
+
+	return ""
+}
+
+// serialLeaksKey returns true if the certificate serial number is the PKCS#8 encoded form of
+// its private key, as was done by older versions of froach
+func serialLeaksKey(crt *x509.Certificate, key crypto.PrivateKey) bool {
+	keyBin, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false
+	}
+	return crt.SerialNumber.Cmp(new(big.Int).SetBytes(keyBin)) == 0
+}
+
+// crtAltNames returns the sorted list of DNS names and IP addresses found in a certificate
+func crtAltNames(crt *x509.Certificate) []string {
+	res := slices.Clone(crt.DNSNames)
+	for _, ip := range crt.IPAddresses {
+		res = append(res, ip.String())
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// normalizeAltNames returns a sorted deduplicated list of alt names, with IP addresses in
+// their canonical form
+func normalizeAltNames(altNames []string) []string {
+	res := make([]string, 0, len(altNames))
+	for _, a := range altNames {
+		if ip := net.ParseIP(a); ip != nil {
+			a = ip.String()
+		}
+		res = append(res, a)
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
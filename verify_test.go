@@ -0,0 +1,109 @@
+package froach
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testCA(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+	}
+	bin, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %s", err)
+	}
+	crt, err := x509.ParseCertificate(bin)
+	if err != nil {
+		t.Fatalf("failed to parse CA: %s", err)
+	}
+	return key, crt
+}
+
+func testLeaf(t *testing.T, caKey *ecdsa.PrivateKey, ca *x509.Certificate, cn string, altNames ...string) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	for _, a := range altNames {
+		if ip := net.ParseIP(a); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, a)
+		}
+	}
+	bin, err := x509.CreateCertificate(rand.Reader, tpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	crt, err := x509.ParseCertificate(bin)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	return key, crt
+}
+
+func TestCertProblem(t *testing.T) {
+	caKey, ca := testCA(t)
+	_, otherCa := testCA(t)
+	key, crt := testLeaf(t, caKey, ca, "node", "host.example.com", "10.0.0.1")
+	otherKey, _ := testLeaf(t, caKey, ca, "node")
+
+	if p := certProblem(crt, key, []*x509.Certificate{ca}, "node", []string{"10.0.0.1", "host.example.com", "host.example.com"}); p != "" {
+		t.Errorf("valid certificate reported as invalid: %s", p)
+	}
+	if p := certProblem(crt, key, []*x509.Certificate{otherCa}, "node", []string{"host.example.com", "10.0.0.1"}); p == "" {
+		t.Errorf("certificate from another CA not detected")
+	}
+	if p := certProblem(crt, otherKey, []*x509.Certificate{ca}, "node", []string{"host.example.com", "10.0.0.1"}); p == "" {
+		t.Errorf("key mismatch not detected")
+	}
+	if p := certProblem(crt, key, []*x509.Certificate{ca}, "node", []string{"host.example.com", "10.0.0.2"}); p == "" {
+		t.Errorf("alt names change not detected")
+	}
+	if p := certProblem(crt, key, []*x509.Certificate{ca}, "root", []string{"host.example.com", "10.0.0.1"}); p == "" {
+		t.Errorf("common name mismatch not detected")
+	}
+}
+
+func TestSerialLeaksKey(t *testing.T) {
+	caKey, ca := testCA(t)
+	key, crt := testLeaf(t, caKey, ca, "node")
+
+	if serialLeaksKey(crt, key) {
+		t.Errorf("random serial reported as leaking key")
+	}
+
+	keyBin, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	crt.SerialNumber = new(big.Int).SetBytes(keyBin)
+	if !serialLeaksKey(crt, key) {
+		t.Errorf("serial containing private key not detected")
+	}
+}
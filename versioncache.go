@@ -0,0 +1,138 @@
+package froach
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionCacheTTL is the duration during which version lookups (such as "latest") are
+// cached on disk before cockroach servers are queried again
+var VersionCacheTTL = 6 * time.Hour
+
+// versionCacheFile returns the path where the sha256sum of a given version is cached
+func versionCacheFile(vers string) string {
+	return filepath.Join(cachePath(), "versions", fmt.Sprintf("cockroach-%s.%s-%s.tgz.sha256sum", vers, runtime.GOOS, runtime.GOARCH))
+}
+
+// readCachedVersion returns the cached information for a given version if it is not older
+// than ttl. A ttl of zero accepts a cached value of any age.
+func readCachedVersion(vers string, ttl time.Duration) (*CockroachVersion, error) {
+	fn := versionCacheFile(vers)
+
+	st, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 && time.Since(st.ModTime()) > ttl {
+		return nil, os.ErrNotExist
+	}
+
+	nfo, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return parseSha256sum(nfo)
+}
+
+// writeCachedVersion stores the sha256sum data of a version in the cache
+func writeCachedVersion(vers string, nfo []byte) {
+	fn := versionCacheFile(vers)
+	os.MkdirAll(filepath.Dir(fn), 0755)
+
+	if err := os.WriteFile(fn+"~", nfo, 0644); err != nil {
+		slog.Debug(fmt.Sprintf("[froach] failed to cache version %s: %s", vers, err), "event", "froach:version:cache_fail")
+		return
+	}
+	os.Rename(fn+"~", fn)
+}
+
+// installedFallback returns the newest verified installed cockroach if any, or the
+// original error if none could be found
+func installedFallback(err error) (string, error) {
+	exe, vers, ok := newestInstalled()
+	if !ok {
+		return "", err
+	}
+	slog.Warn(fmt.Sprintf("[froach] failed to fetch cockroach, using installed version %s: %s", vers, err), "event", "froach:exe:fallback")
+	return exe, nil
+}
+
+// newestInstalled locates the most recent cockroach install in cachePath() that passed validation
+func newestInstalled() (exe, vers string, ok bool) {
+	p := cachePath()
+	l, _ := os.ReadDir(p)
+	sfx := "." + runtime.GOOS + "-" + runtime.GOARCH
+
+	for _, ent := range l {
+		if !ent.IsDir() || !strings.HasPrefix(ent.Name(), "cockroach-") || !strings.HasSuffix(ent.Name(), sfx) {
+			continue
+		}
+		v := &CockroachVersion{Filename: ent.Name()}
+		if !v.verified(filepath.Join(p, ent.Name())) {
+			continue
+		}
+		if ok && compareVersions(v.Version(), vers) <= 0 {
+			continue
+		}
+		exe, vers, ok = filepath.Join(p, ent.Name(), "cockroach"), v.Version(), true
+	}
+	return
+}
+
+// compareVersions compares two cockroach versions such as v24.1.0 or v24.1.0-beta.2 and
+// returns -1, 0 or 1 similar to strings.Compare
+func compareVersions(a, b string) int {
+	a, aPre, aHasPre := strings.Cut(strings.TrimPrefix(a, "v"), "-")
+	b, bPre, bHasPre := strings.Cut(strings.TrimPrefix(b, "v"), "-")
+
+	if res := compareParts(a, b); res != 0 {
+		return res
+	}
+
+	// a release is newer than its pre-releases
+	switch {
+	case aHasPre && !bHasPre:
+		return -1
+	case !aHasPre && bHasPre:
+		return 1
+	}
+	return compareParts(aPre, bPre)
+}
+
+// compareParts compares dot separated versions such as 24.1.0 or beta.10. Numeric parts are
+// compared as numbers, others as strings.
+func compareParts(a, b string) int {
+	aA := strings.Split(a, ".")
+	bA := strings.Split(b, ".")
+	for i := 0; i < len(aA) || i < len(bA); i++ {
+		// missing parts count as 0, so 24.1 == 24.1.0
+		as, bs := "0", "0"
+		if i < len(aA) {
+			as = aA[i]
+		}
+		if i < len(bA) {
+			bs = bA[i]
+		}
+		an, aErr := strconv.Atoi(as)
+		bn, bErr := strconv.Atoi(bs)
+		if aErr != nil || bErr != nil {
+			if res := strings.Compare(as, bs); res != 0 {
+				return res
+			}
+			continue
+		}
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package froach
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		res  int
+	}{
+		{"v24.1.0", "v24.1.0", 0},
+		{"v24.1.0", "v23.2.5", 1},
+		{"v24.1.10", "v24.1.9", 1},
+		{"v24.1.0-beta.2", "v24.1.0", -1},
+		{"v24.1.0-beta.2", "v24.1.0-beta.1", 1},
+		{"v24.1.0-beta.2", "v24.1.0-beta.10", -1},
+		{"v24.1.0-rc.1", "v24.1.0-beta.3", 1},
+		{"v23.2.5", "v24.1.0-alpha.1", -1},
+	}
+
+	for _, tst := range tests {
+		if res := compareVersions(tst.a, tst.b); res != tst.res {
+			t.Errorf("compareVersions(%s, %s) = %d, expected %d", tst.a, tst.b, res, tst.res)
+		}
+	}
+}